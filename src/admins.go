@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// adminCache keeps the admins of each chat, so they are not fetched on every message.
+type adminCache struct {
+	sync.Mutex
+	chats map[int64]*chatAdmins
+}
+
+type chatAdmins struct {
+	ids map[int64]bool
+	// whether the bot is an admin with the right to restrict members
+	canRestrict bool
+	fetchedAt   time.Time
+}
+
+var gAdminsRefreshInterval = 10 * time.Minute
+
+var admins = adminCache{chats: make(map[int64]*chatAdmins)}
+
+// IsAdmin reports whether the user is the creator or an administrator of the chat.
+func (a *adminCache) IsAdmin(chat *tele.Chat, userID int64) bool {
+	a.refresh(chat)
+	a.Lock()
+	defer a.Unlock()
+	return a.chats[chat.ID].ids[userID]
+}
+
+// CanRestrict reports whether the bot has the right to restrict members of the chat.
+func (a *adminCache) CanRestrict(chat *tele.Chat) bool {
+	a.refresh(chat)
+	a.Lock()
+	defer a.Unlock()
+	return a.chats[chat.ID].canRestrict
+}
+
+// refresh refetches the admin list of the chat once it is older than gAdminsRefreshInterval,
+// without holding the cache, so a slow chat does not hold up the admin checks of the others.
+func (a *adminCache) refresh(chat *tele.Chat) {
+	a.Lock()
+	ca := a.chats[chat.ID]
+	fresh := ca != nil && time.Since(ca.fetchedAt) <= gAdminsRefreshInterval
+	a.Unlock()
+	if fresh {
+		return
+	}
+	fetched := fetchAdmins(chat)
+	a.Lock()
+	defer a.Unlock()
+	if fetched == nil {
+		// keep the old list until the next refresh
+		fetched = &chatAdmins{ids: make(map[int64]bool), fetchedAt: time.Now()}
+		if old := a.chats[chat.ID]; old != nil {
+			fetched.ids = old.ids
+			fetched.canRestrict = old.canRestrict
+		}
+	}
+	a.chats[chat.ID] = fetched
+}
+
+// fetchAdmins gets the admin list of the chat from Telegram, nil if it fails.
+func fetchAdmins(chat *tele.Chat) *chatAdmins {
+	members, err := bot.AdminsOf(chat)
+	if err != nil {
+		errLog.Error("Fetch admins", "chat", chat.ID, "err", err)
+		return nil
+	}
+	ca := &chatAdmins{ids: make(map[int64]bool), fetchedAt: time.Now()}
+	for _, m := range members {
+		ca.ids[m.User.ID] = true
+		if m.User.ID == bot.Me.ID {
+			ca.canRestrict = m.Role == tele.Administrator && m.CanRestrictMembers
+		}
+	}
+	return ca
+}
+
+// Update applies a chat member update to the cached admin list.
+func (a *adminCache) Update(u *tele.ChatMemberUpdate) {
+	if u.NewChatMember == nil || u.NewChatMember.User == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	ca := a.chats[u.Chat.ID]
+	if ca == nil {
+		return
+	}
+	role := u.NewChatMember.Role
+	ca.ids[u.NewChatMember.User.ID] = role == tele.Creator || role == tele.Administrator
+	if u.NewChatMember.User.ID == bot.Me.ID {
+		ca.canRestrict = role == tele.Administrator && u.NewChatMember.CanRestrictMembers
+	}
+}
+
+// isAdminMessage reports whether the message is sent by an admin, anonymous admins included.
+func isAdminMessage(c tele.Context) bool {
+	if c.Message().SenderChat != nil && c.Message().SenderChat.ID == c.Chat().ID {
+		return true
+	}
+	return admins.IsAdmin(c.Chat(), c.Sender().ID)
+}
+
+// onMyChatMember keeps the rights of the bot in the cached admin list.
+func onMyChatMember(c tele.Context) error {
+	admins.Update(c.ChatMember())
+	return nil
+}
+
+func onChatMember(c tele.Context) error {
+	u := c.ChatMember()
+	admins.Update(u)
+	if u.OldChatMember != nil && u.NewChatMember != nil && u.NewChatMember.User != nil {
+		wasIn := u.OldChatMember.Role != tele.Left && u.OldChatMember.Role != tele.Kicked
+		isIn := u.NewChatMember.Role != tele.Left && u.NewChatMember.Role != tele.Kicked
+		if !wasIn && isIn {
+			findGroupByContext(c).UserJoined(u.NewChatMember.User, u.Time())
+		}
+	}
+	return nil
+}
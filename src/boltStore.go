@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltGroups  = []byte("groups")
+	boltPending = []byte("msg2delete")
+	boltBot     = []byte("bot")
+	boltEvents  = []byte("events")
+	boltMeta    = []byte("meta")
+	boltVersion = []byte("version")
+	// the only key of the buckets holding a single document
+	boltDoc = []byte("doc")
+)
+
+// boltStore keeps everything in one bbolt file, each group under its chat id,
+// so a save only rewrites the pages of the groups and not the whole state.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore(dir string) (*boltStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, "state.db"), 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltGroups, boltPending, boltBot, boltEvents, boltMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) LoadGroups() ([]*GroupStat, error) {
+	list := make([]*GroupStat, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltGroups).ForEach(func(k, v []byte) error {
+			g := &GroupStat{}
+			if err := json.Unmarshal(v, g); err != nil {
+				return err
+			}
+			list = append(list, g)
+			return nil
+		})
+	})
+	return list, err
+}
+func (s *boltStore) SaveGroups(groups []*GroupStat, version int) error {
+	meta := make([]byte, 8)
+	binary.BigEndian.PutUint64(meta, uint64(version))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltMeta).Put(boltVersion, meta); err != nil {
+			return err
+		}
+		b := tx.Bucket(boltGroups)
+		for _, g := range groups {
+			data, err := json.Marshal(g)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(g.Id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// load reads the single document of the bucket into v, leaving v as is if there is none.
+func (s *boltStore) load(bucket []byte, v interface{}) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucket).Get(boltDoc)
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, v)
+	})
+}
+func (s *boltStore) save(bucket []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(boltDoc, data)
+	})
+}
+
+func (s *boltStore) LoadPendingDeletes() ([]MsgWithTimeout, error) {
+	msgs := make([]MsgWithTimeout, 0)
+	err := s.load(boltPending, &msgs)
+	return msgs, err
+}
+func (s *boltStore) SavePendingDeletes(msgs []MsgWithTimeout) error {
+	return s.save(boltPending, msgs)
+}
+func (s *boltStore) LoadBotStat() (BotStat, error) {
+	var stat BotStat
+	err := s.load(boltBot, &stat)
+	return stat, err
+}
+func (s *boltStore) SaveBotStat(stat BotStat) error {
+	return s.save(boltBot, stat)
+}
+
+func (s *boltStore) LoadSchemaVersion() (int, error) {
+	var version int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(boltMeta).Get(boltVersion); len(data) == 8 {
+			version = int(binary.BigEndian.Uint64(data))
+		}
+		return nil
+	})
+	return version, err
+}
+
+// Backup copies a consistent view of the whole file next to it.
+func (s *boltStore) Backup(version int) (string, error) {
+	path := fmt.Sprintf("%s.v%d-%s.bak", s.db.Path(), version, time.Now().Format(gBackupTimeFormat))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0644)
+	})
+	return path, err
+}
+
+func (s *boltStore) AppendEvents(events []Event) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltEvents)
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+			if seq <= gEventsMax {
+				continue
+			}
+			oldest := make([]byte, 8)
+			binary.BigEndian.PutUint64(oldest, seq-gEventsMax)
+			if err := b.Delete(oldest); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+func (s *boltStore) EachEvent(fn func(e Event) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltEvents).ForEach(func(k, v []byte) error {
+			var e Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			return fn(e)
+		})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
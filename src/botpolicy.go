@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+)
+
+// Bot policies of a group
+const (
+	// BotBan deletes every inline message via the bot.
+	BotBan = "ban"
+	// BotAllow never counts inline messages via the bot.
+	BotAllow = "allow"
+)
+
+// BotPolicy returns the policy of the bot, empty if it is limited as usual.
+// In allowlist only groups, every bot not allowed is banned.
+func (g *GroupStat) BotPolicy(name string) string {
+	if p := g.BotPolicies[name]; p != "" {
+		return p
+	}
+	if g.Setup.BotAllowlistOnly {
+		return BotBan
+	}
+	return ""
+}
+
+// SetBotPolicy sets the policy of the bot, an empty policy removes it.
+func (g *GroupStat) SetBotPolicy(name string, policy string) {
+	if policy == "" {
+		delete(g.BotPolicies, name)
+		return
+	}
+	if g.BotPolicies == nil {
+		g.BotPolicies = make(map[string]string)
+	}
+	g.BotPolicies[name] = policy
+}
+
+func botPoliciesText(group *GroupStat) string {
+	names := make([]string, 0, len(group.BotPolicies))
+	for name := range group.BotPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	text := ""
+	if group.Setup.BotAllowlistOnly {
+		text += "\nOnly allowed bots can be used."
+	}
+	for _, name := range names {
+		if group.BotPolicies[name] == BotBan {
+			text += "\nBot @" + name + " is banned."
+		} else {
+			text += "\nBot @" + name + " is allowed and never counted."
+		}
+	}
+	return text
+}
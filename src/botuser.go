@@ -0,0 +1,70 @@
+package main
+
+import (
+	"time"
+)
+
+// HasUserLimit reports whether each user of the bot is limited on their own.
+func (b *BotSetup) HasUserLimit() bool {
+	return b.UserBurnoutLimit > 0
+}
+
+// HasBotLimit reports whether the bot is limited for the whole group.
+func (b *BotSetup) HasBotLimit() bool {
+	return b.BurnoutLimit > 0
+}
+
+// ClearBotLimit removes the limit of the whole group, the per-user limit stays.
+func (b *BotSetup) ClearBotLimit() {
+	b.BurnoutLimit = 0
+	b.CooldownMinutes = 0
+	b.Until = time.Time{}
+	b.Warned = false
+	b.Reset()
+}
+
+// UserSetup returns the per-user limit of the bot.
+func (b *BotSetup) UserSetup() GroupSetup {
+	return GroupSetup{CooldownMinutes: b.UserCooldownMinutes, BurnoutLimit: b.UserBurnoutLimit, Mode: b.Mode}
+}
+
+// UserCounter returns the counter of the user of the bot, creating it if needed.
+func (b *BotSetup) UserCounter(id string) *Counter {
+	if b.UserCounters == nil {
+		b.UserCounters = make(map[string]*Counter)
+	}
+	c, ok := b.UserCounters[id]
+	if !ok {
+		c = &Counter{}
+		b.UserCounters[id] = c
+	}
+	return c
+}
+
+// IsUserBurned reports whether the user burned out their own access to the bot.
+func (b *BotSetup) IsUserBurned(id string, now time.Time) bool {
+	c, ok := b.UserCounters[id]
+	return ok && b.HasUserLimit() && b.UserSetup().IsBurned(c, now)
+}
+
+// UserNextAllowed returns when the user can use the bot again.
+func (b *BotSetup) UserNextAllowed(id string, now time.Time) time.Time {
+	return b.UserSetup().NextAllowed(b.UserCounter(id), now)
+}
+
+// UserCountAdd counts an inline message of the user via the bot.
+func (b *BotSetup) UserCountAdd(id string, now time.Time) {
+	if b.HasUserLimit() {
+		b.UserSetup().Add(b.UserCounter(id), now)
+	}
+}
+
+// RefreshUsers refreshes the per-user counters and forgets the users who cooled down.
+func (b *BotSetup) RefreshUsers(now time.Time) {
+	s := b.UserSetup()
+	for id, c := range b.UserCounters {
+		if s.Refresh(c, now); c.Count == 0 {
+			delete(b.UserCounters, id)
+		}
+	}
+}
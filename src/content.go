@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Content classes limited like inline messages
+const (
+	ContentSticker   = "sticker"
+	ContentAnimation = "animation"
+	ContentDice      = "dice"
+	ContentPoll      = "poll"
+	// ContentForward is a message forwarded from a channel.
+	ContentForward = "forward"
+	ContentVoice   = "voice"
+)
+
+var gContentClasses = []string{ContentSticker, ContentAnimation, ContentDice, ContentPoll, ContentForward, ContentVoice}
+
+// gContentTierPrefix names the content limits among the tiers of a user, tier names can not contain it
+const gContentTierPrefix = "content:"
+
+// contentClass returns the limited class of the message, empty if there is none.
+// The channel posts copied into the linked discussion group are not sent by a user.
+func contentClass(m *tele.Message) string {
+	switch {
+	case m.AutomaticForward:
+		return ""
+	case m.OriginalChat != nil && m.OriginalChat.Type == tele.ChatChannel:
+		return ContentForward
+	case m.Sticker != nil:
+		return ContentSticker
+	case m.Animation != nil:
+		return ContentAnimation
+	case m.Dice != nil:
+		return ContentDice
+	case m.Poll != nil:
+		return ContentPoll
+	case m.Voice != nil:
+		return ContentVoice
+	}
+	return ""
+}
+
+// contentOf returns the content class limited by the tier, empty if it is not a content limit.
+func contentOf(tier string) string {
+	class, ok := strings.CutPrefix(tier, gContentTierPrefix)
+	if !ok {
+		return ""
+	}
+	return class
+}
+
+// GetContentLimit returns the limit of the content class, nil if it is not limited.
+func (g *GroupStat) GetContentLimit(class string) *LimitTier {
+	for i, v := range g.Setup.Contents {
+		if v.Name == class {
+			return &g.Setup.Contents[i]
+		}
+	}
+	return nil
+}
+func (g *GroupStat) SetContentLimit(class string, cooldown int, burnout int) {
+	if l := g.GetContentLimit(class); l != nil {
+		l.CooldownMinutes = cooldown
+		l.BurnoutLimit = burnout
+		return
+	}
+	g.Setup.Contents = append(g.Setup.Contents, LimitTier{Name: class, CooldownMinutes: cooldown, BurnoutLimit: burnout})
+}
+func (g *GroupStat) RemoveContentLimit(class string) bool {
+	for i, v := range g.Setup.Contents {
+		if v.Name == class {
+			g.Setup.Contents = append(g.Setup.Contents[:i], g.Setup.Contents[i+1:]...)
+			for _, u := range g.Users {
+				delete(u.TierCounters, gContentTierPrefix+class)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// ContentBurnout returns the tier of the content limit the user burned out and when the next message is allowed,
+// an empty tier if the message is allowed.
+func (g *GroupStat) ContentBurnout(u *User, class string, now time.Time) (tier string, next time.Time) {
+	l := g.GetContentLimit(class)
+	if l == nil {
+		return "", now
+	}
+	s := g.TierSetup(*l)
+	c := u.TierCounter(gContentTierPrefix + class)
+	if !s.IsBurned(c, now) {
+		return "", now
+	}
+	return gContentTierPrefix + class, s.NextAllowed(c, now)
+}
+
+func (g *GroupStat) ContentCountAdd(u *User, class string, now time.Time) {
+	if l := g.GetContentLimit(class); l != nil {
+		g.TierSetup(*l).Add(u.TierCounter(gContentTierPrefix+class), now)
+	}
+}
+
+// contentMessageHandler limits a message of a limited content class like an inline message.
+func contentMessageHandler(c tele.Context, class string) error {
+	group := findGroupByContext(c)
+	user := group.GetUser(strconv.FormatInt(c.Sender().ID, 10))
+	var resultLog string
+	kind := EventAllowed
+	now := time.Now()
+	var burned string
+
+	if group.IsUserExempt(user.Id) || (group.Setup.AdminsExempt && isAdminMessage(c)) {
+		resultLog = "[EXEMPT]"
+		group.MsgCount("chat")
+	} else if tier, next := group.ContentBurnout(user, class, now); tier != "" {
+		burned = tier
+		if group.IsDryRun() {
+			resultLog = "[BURNED](CONTENT)(DRYRUN)"
+			group.MsgCount("chat")
+			group.WouldBlockUser(user.Id, fullName(c.Sender()), group.LadderStep(user.Id, now).Action)
+		} else {
+			resultLog, kind = enforceUserBurnout(c, group, user, tier, next, 1, now)
+		}
+	} else {
+		resultLog = "[ALLOWED]"
+		group.ContentCountAdd(user, class, now)
+		group.MsgCount("chat")
+	}
+
+	l := group.GetContentLimit(class)
+	details := fmt.Sprintf("Chat %s\nUser @%s %s:%d/%d", group.Id, user.Id, class, user.TierCounter(gContentTierPrefix+class).Count, l.BurnoutLimit)
+	msgLog.Info(resultLog, "detail", details)
+	event := Event{Time: now, Kind: kind, Chat: group.Id, User: user.Id, Result: resultLog, Tier: burned, Weight: 1}
+	event.AddCount(gContentTierPrefix+class, user.TierCounter(gContentTierPrefix+class).Count, l.BurnoutLimit)
+	logEvent(event)
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// DryRun keeps what would have been blocked while a group only observes.
+type DryRun struct {
+	// The admin who enabled the dry run, receiving the reports
+	AdminId int64     `json:"adminid"`
+	Since   time.Time `json:"since"`
+	// Messages that would have been blocked by user id
+	Users map[string]*DryRunHits `json:"users"`
+	// Messages that would have been blocked by bot username
+	Bots map[string]int `json:"bots"`
+}
+
+type DryRunHits struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	// The most severe ladder step reached
+	Action string `json:"action"`
+}
+
+func newDryRun(adminID int64) *DryRun {
+	return &DryRun{AdminId: adminID, Since: time.Now(), Users: make(map[string]*DryRunHits), Bots: make(map[string]int)}
+}
+
+func (g *GroupStat) IsDryRun() bool {
+	return g.DryRun != nil
+}
+
+// WouldBlockUser counts an inline message the user would have been blocked for.
+func (g *GroupStat) WouldBlockUser(id string, name string, action string) {
+	g.MsgCount("wouldblock")
+	h := g.DryRun.Users[id]
+	if h == nil {
+		h = &DryRunHits{}
+		g.DryRun.Users[id] = h
+	}
+	h.Name = name
+	h.Count++
+	if severity(action) > severity(h.Action) {
+		h.Action = action
+	}
+}
+
+// WouldBlockBot counts an inline message the bot would have been blocked for.
+func (g *GroupStat) WouldBlockBot(name string) {
+	g.MsgCount("wouldblock")
+	g.DryRun.Bots[name]++
+}
+
+func (d *DryRun) Report(gid string) string {
+	text := fmt.Sprintf("Dry run report of chat %s since %s", gid, d.Since.Format("2006-01-02 15:04"))
+	if len(d.Users) == 0 && len(d.Bots) == 0 {
+		return text + "\nNothing would have been blocked."
+	}
+	ids := make([]string, 0, len(d.Users))
+	for id := range d.Users {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return d.Users[ids[i]].Count > d.Users[ids[j]].Count })
+	for _, id := range ids {
+		h := d.Users[id]
+		text += fmt.Sprintf("\n%s (%s): %d inline messages would have been blocked, up to %s", h.Name, id, h.Count, h.Action)
+	}
+	for name, n := range d.Bots {
+		text += fmt.Sprintf("\nBot @%s: %d inline messages would have been blocked", name, n)
+	}
+	return text
+}
+
+// SendDryRunReport sends the report to the admin who enabled the dry run in private, once the group is released.
+func (g *GroupStat) SendDryRunReport() {
+	if g.DryRun == nil {
+		return
+	}
+	gid, admin, report := g.Id, g.DryRun.AdminId, g.DryRun.Report(g.Id)
+	g.later(func() {
+		if _, err := bot.Send(&tele.User{ID: admin}, report); err != nil {
+			errLog.Error("Send dry run report", "chat", gid, "admin", admin, "err", err)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Enforcement modes of GroupSetup
+const (
+	// EnforceDelete deletes the messages over the limit and warns the sender.
+	EnforceDelete = "delete"
+	// EnforceRestrict takes the right to send inline messages from the burned out members until their cooldown ends.
+	EnforceRestrict = "restrict"
+)
+
+// Telegram restricts forever when the restriction is shorter than this
+var gRestrictMin = time.Minute
+
+// EnforcementName returns the enforcement for display, data saved by older versions has none.
+func (s GroupSetup) EnforcementName() string {
+	if s.Enforcement == "" {
+		return EnforceDelete
+	}
+	return s.Enforcement
+}
+
+// canRestrict reports whether the bot has the right to restrict members of the chat, from the cached admin list.
+func canRestrict(chat *tele.Chat) bool {
+	return admins.CanRestrict(chat)
+}
+
+// chatRights returns the default rights of the members of the chat.
+func chatRights(chat *tele.Chat) tele.Rights {
+	full, err := bot.ChatByID(chat.ID)
+	if err != nil || full.Permissions == nil {
+		return tele.NoRestrictions()
+	}
+	return *full.Permissions
+}
+
+// RestrictUser takes the right to send inline messages from the user until the given time, once the group is released.
+// It returns false if the user is not restricted, so the caller falls back to delete mode.
+func (g *GroupStat) RestrictUser(chat *tele.Chat, user *tele.User, until time.Time) bool {
+	if until.IsZero() || time.Until(until) < gRestrictMin || !canRestrict(chat) {
+		return false
+	}
+	m := g.NewMember(strconv.FormatInt(user.ID, 10))
+	m.Name = fullName(user)
+	m.RestrictedUntil = until
+	g.later(func() {
+		rights := chatRights(chat)
+		rights.CanSendOther = false
+		err := bot.Restrict(chat, &tele.ChatMember{User: user, Rights: rights, RestrictedUntil: until.Unix()})
+		if err != nil {
+			errLog.Error("Restrict member", "chat", chat.ID, "user", user.ID, "err", err)
+		}
+	})
+	return true
+}
+
+// liftRestriction gives the default rights of the chat back to the member, once the group is released.
+func (g *GroupStat) liftRestriction(m *Member) {
+	gid, _ := strconv.ParseInt(g.Id, 10, 64)
+	uid, _ := strconv.ParseInt(m.Id, 10, 64)
+	m.RestrictedUntil = time.Time{}
+	g.later(func() {
+		chat := &tele.Chat{ID: gid}
+		err := bot.Restrict(chat, &tele.ChatMember{User: &tele.User{ID: uid}, Rights: chatRights(chat)})
+		if err != nil {
+			errLog.Error("Lift restriction", "chat", gid, "user", uid, "err", err)
+		}
+	})
+}
+
+// RestrictionRoutine lifts the restrictions of the members whose cooldown is over,
+// at the end of the restriction or earlier on heatsink and setup changes.
+// The mutes of the ladder are lifted at their end only.
+func (g *GroupStat) RestrictionRoutine(now time.Time) {
+	for i := range g.Members {
+		m := &g.Members[i]
+		if !m.MutedUntil.IsZero() {
+			if !now.Before(m.MutedUntil) {
+				g.liftRestriction(m)
+				m.MutedUntil = time.Time{}
+				timerLog.Info("[UNMUTE]", "detail", "Chat "+g.Id+"\nUser @"+m.Id)
+			}
+			continue
+		}
+		if m.RestrictedUntil.IsZero() {
+			continue
+		}
+		burned := false
+		if u := g.lookupUser(m.Id); u != nil {
+			tier, _ := g.UserBurnout(u, now)
+			burned = tier != ""
+		}
+		if !burned || !now.Before(m.RestrictedUntil) {
+			g.liftRestriction(m)
+			timerLog.Info("[UNRESTRICT]", "detail", "Chat "+g.Id+"\nUser @"+m.Id)
+		}
+	}
+}
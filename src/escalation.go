@@ -0,0 +1,103 @@
+package main
+
+import (
+	"time"
+)
+
+// EscalationPolicy multiplies the cooldown of the members burning out repeatedly.
+// The n-th burnout within LookbackHours waits Factor^(n-1) times as long, up to MaxMultiplier.
+type EscalationPolicy struct {
+	Factor        int
+	MaxMultiplier int
+	LookbackHours int
+}
+
+func (p *EscalationPolicy) lookback() time.Duration {
+	return time.Duration(p.LookbackHours) * time.Hour
+}
+
+// Multiplier returns the cooldown multiplier of the n-th burnout.
+func (p *EscalationPolicy) Multiplier(n int) int {
+	m := 1
+	for i := 1; i < n && m < p.MaxMultiplier; i++ {
+		m *= p.Factor
+	}
+	if m > p.MaxMultiplier {
+		m = p.MaxMultiplier
+	}
+	return m
+}
+
+// burnoutLookback returns how long the burnouts are kept, for the escalation policy and the ladder.
+func (g *GroupStat) burnoutLookback() time.Duration {
+	var lookback time.Duration
+	if g.Setup.Escalation != nil {
+		lookback = g.Setup.Escalation.lookback()
+	}
+	if g.Setup.hasBurnoutSteps() && lookback < gLadderBurnoutWindow {
+		lookback = gLadderBurnoutWindow
+	}
+	return lookback
+}
+
+// BurnoutsWithin returns how many times the user burned out in the given period until now.
+func (m *Member) BurnoutsWithin(now time.Time, period time.Duration) int {
+	n := 0
+	for _, t := range m.Burnouts {
+		if now.Sub(t) <= period {
+			n++
+		}
+	}
+	return n
+}
+
+// RecordBurnout is called when a message burns the user out, it escalates the cooldown
+// of the user if the group has an escalation policy and returns the multiplier.
+func (g *GroupStat) RecordBurnout(u *User, now time.Time) int {
+	if m := g.GetMember(u.Id); m != nil {
+		m.Offences = 0
+	}
+	lookback := g.burnoutLookback()
+	if lookback == 0 {
+		return 1
+	}
+	m := g.NewMember(u.Id)
+	m.Burnouts = append(m.Burnouts, now)
+	m.pruneBurnouts(now, lookback)
+	p := g.Setup.Escalation
+	if p == nil {
+		return 1
+	}
+	multiplier := p.Multiplier(m.BurnoutsWithin(now, p.lookback()))
+	if _, next := g.UserBurnout(u, now); multiplier > 1 && !next.IsZero() {
+		m.PenaltyUntil = now.Add(next.Sub(now) * time.Duration(multiplier))
+		m.Penalty = multiplier
+	}
+	return multiplier
+}
+
+// pruneBurnouts lets the burnout history decay after the lookback period.
+func (m *Member) pruneBurnouts(now time.Time, lookback time.Duration) {
+	i := 0
+	for i < len(m.Burnouts) && now.Sub(m.Burnouts[i]) > lookback {
+		i++
+	}
+	m.Burnouts = m.Burnouts[i:]
+	if len(m.Burnouts) == 0 {
+		m.Burnouts = nil
+	}
+	if !now.Before(m.PenaltyUntil) {
+		m.PenaltyUntil = time.Time{}
+		m.Penalty = 0
+	}
+}
+
+// EscalatedUntil returns when the escalated cooldown of the user ends and its multiplier,
+// zero if there is none at now.
+func (g *GroupStat) EscalatedUntil(id string, now time.Time) (time.Time, int) {
+	m := g.GetMember(id)
+	if m == nil || !now.Before(m.PenaltyUntil) {
+		return time.Time{}, 0
+	}
+	return m.PenaltyUntil, m.Penalty
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Event kinds
+const (
+	// EventAllowed is a message kept, including exempt users, allowed bots and dry runs.
+	EventAllowed = "allowed"
+	// EventBlockedUser is a message deleted because the user burned out, whatever the ladder step.
+	EventBlockedUser = "blocked-user"
+	// EventBlockedBot is a message deleted because the bot burned out or is banned.
+	EventBlockedBot = "blocked-bot"
+	// EventDeleted is a message deleted regardless of any limit, during a lockdown.
+	EventDeleted = "deleted"
+	// EventWarned is a message over the limit kept by the warn step of the ladder.
+	EventWarned = "warned"
+	// EventSetup is a change of the setup by an admin.
+	EventSetup = "setup"
+	// EventHeatsink is a reset of every counter by an admin.
+	EventHeatsink = "heatsink"
+)
+
+// gEventLogMaxSize rotates the JSON event log when it grows past it, keeping gEventLogFiles old files
+var gEventLogMaxSize int64 = 16 << 20
+var gEventLogFiles = 5
+
+// gEventsMax is how many events the bbolt backend keeps
+var gEventsMax uint64 = 500000
+
+// gEventFlushInterval and gEventBatchMax bound how long and how many events wait in the queue before they are stored
+var gEventFlushInterval = time.Second
+var gEventBatchMax = 256
+
+// gEventQueueTimeout is how long a handler waits on a full queue before the event is dropped
+var gEventQueueTimeout = 100 * time.Millisecond
+
+var (
+	// eventQueue holds the events logged by the handlers until eventWriter stores them
+	eventQueue = make(chan Event, 4096)
+	// eventFlush asks eventWriter to store the queued events right away
+	eventFlush = make(chan chan struct{})
+	// eventsDropped counts the events dropped on a full queue since the last summary
+	eventsDropped atomic.Int64
+)
+
+// Event is a moderation decision kept in the event history.
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	Chat string    `json:"chat"`
+	// The sender of the message, or the admin changing the setup
+	User string `json:"user,omitempty"`
+	// Username of the inline bot
+	Bot string `json:"bot,omitempty"`
+	// The tag of the log line, like [BURNED](USER)(MUTED)
+	Result string `json:"result,omitempty"`
+	// Tier the user burned out
+	Tier   string `json:"tier,omitempty"`
+	Weight int    `json:"weight,omitempty"`
+	// Counters after the decision
+	Counts []EventCount `json:"counts,omitempty"`
+	// The command of setup changes
+	Detail string `json:"detail,omitempty"`
+}
+
+// EventCount is a counter and its limit at the time of the event.
+type EventCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Limit int    `json:"limit"`
+}
+
+func (e *Event) AddCount(name string, count int, limit int) {
+	e.Counts = append(e.Counts, EventCount{Name: name, Count: count, Limit: limit})
+}
+
+// logEvent queues the event for eventWriter, the handlers do not wait on the storage.
+// On a full queue it waits up to gEventQueueTimeout, then drops the event and counts it for the summary.
+func logEvent(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case eventQueue <- e:
+		return
+	default:
+	}
+	timeout := time.NewTimer(gEventQueueTimeout)
+	defer timeout.Stop()
+	select {
+	case eventQueue <- e:
+	case <-timeout.C:
+		eventsDropped.Add(1)
+		errLog.Error("Drop event, the queue is full", "kind", e.Kind, "chat", e.Chat)
+	}
+}
+
+// eventWriter appends the queued events to the storage in batches of up to gEventBatchMax,
+// at least every gEventFlushInterval.
+func eventWriter() {
+	interval := time.NewTicker(gEventFlushInterval)
+	defer interval.Stop()
+	batch := make([]Event, 0, gEventBatchMax)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := store.AppendEvents(batch); err != nil {
+			errLog.Error("Append events", "count", len(batch), "err", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case e := <-eventQueue:
+			if batch = append(batch, e); len(batch) >= gEventBatchMax {
+				flush()
+			}
+		case <-interval.C:
+			flush()
+		case done := <-eventFlush:
+			for len(eventQueue) > 0 {
+				if batch = append(batch, <-eventQueue); len(batch) >= gEventBatchMax {
+					flush()
+				}
+			}
+			flush()
+			close(done)
+		}
+	}
+}
+
+// flushEvents returns once eventWriter stored every event logged before.
+func flushEvents() {
+	done := make(chan struct{})
+	eventFlush <- done
+	<-done
+}
+
+// logSetupEvent records a change of the setup made by the admin sending the message.
+func logSetupEvent(c tele.Context, group *GroupStat, detail string) {
+	logEvent(Event{Kind: EventSetup, Chat: group.Id, User: strconv.FormatInt(c.Sender().ID, 10), Detail: detail})
+}
+
+// setupDigest marshals what the setup commands change, to tell whether a command changed anything.
+func (g *GroupStat) setupDigest() string {
+	data, _ := json.Marshal(struct {
+		Setup         GroupSetup
+		BotsSetup     []BotSetup
+		Pools         []BotSetup
+		Members       []Member
+		DryRun        bool
+		LockdownUntil time.Time
+		Relax         *Relaxation
+		BotPolicies   map[string]string
+	}{g.Setup, g.BotsSetup, g.Pools, g.Members, g.DryRun != nil, g.LockdownUntil, g.Relax, g.BotPolicies})
+	return string(data)
+}
@@ -4,16 +4,15 @@ go 1.21
 
 require (
 	github.com/Nigh/kuma-push v0.1.0
+	github.com/charmbracelet/lipgloss v0.10.0
 	github.com/charmbracelet/log v0.4.0
-	github.com/nanobox-io/golang-scribble v0.0.0-20190309225732-aa3e7c118975
+	go.etcd.io/bbolt v1.3.10
 	gopkg.in/telebot.v3 v3.2.1
 )
 
 require (
 	github.com/aymanbagabas/go-osc52/v2 v2.0.1 // indirect
-	github.com/charmbracelet/lipgloss v0.10.0 // indirect
 	github.com/go-logfmt/logfmt v0.6.0 // indirect
-	github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-isatty v0.0.18 // indirect
 	github.com/mattn/go-runewidth v0.0.15 // indirect
@@ -2,16 +2,19 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/log"
+	tele "gopkg.in/telebot.v3"
 )
 
 type User struct {
 	Id string `json:"id"`
-	// Cooldown time left in minutes
-	Cooldown int `json:"cooldown"`
-	// Count of valid inline messages
-	Count int `json:"count"`
+	Counter
+	// Counters of the extra limit tiers by name
+	TierCounters map[string]*Counter `json:"tiercounters,omitempty"`
 }
 type Stat struct {
 	InlineCount int
@@ -21,17 +24,75 @@ type Stat struct {
 type GroupSetup struct {
 	CooldownMinutes int
 	BurnoutLimit    int
+	Mode            string
+	// Extra limits checked together with the main one
+	Tiers []LimitTier `json:",omitempty"`
+	// Rules replacing the main limit at certain times
+	Schedules []ScheduleRule `json:",omitempty"`
+	// Timezone of the schedules, the TZ of the bot if empty
+	Timezone string `json:",omitempty"`
+	// Creators and administrators of the group are never limited
+	AdminsExempt bool `json:",omitempty"`
+	// Limit of the members who joined recently, nil if there is none
+	Newcomer *NewcomerPolicy `json:",omitempty"`
+	// Longer cooldowns for repeated burnouts, nil if there is none
+	Escalation *EscalationPolicy `json:",omitempty"`
+	// How burned out members are stopped, delete mode if empty
+	Enforcement string `json:",omitempty"`
+	// Steps taken against the messages over the limit, deleting all of them if empty
+	Ladder []LadderStep `json:",omitempty"`
+	// Limits of content classes like stickers, named after the class
+	Contents []LimitTier `json:",omitempty"`
+	// What an inline message of a content type costs against the user limits, 1 if not set
+	Weights map[string]int `json:",omitempty"`
+	// Only the bots allowed by /botallow can be used
+	BotAllowlistOnly bool `json:",omitempty"`
+}
+
+// NewcomerPolicy replaces the user limit for the members during probation after they joined.
+type NewcomerPolicy struct {
+	Hours        int
+	BurnoutLimit int
+	// No inline messages at all during probation
+	Ban bool
+}
+
+type LimitTier struct {
+	Name            string
+	CooldownMinutes int
+	BurnoutLimit    int
 }
 
 type BotSetup struct {
 	GroupSetup
 	User
 	Warned bool
+	// The limit is removed then, zero if it is permanent
+	Until time.Time `json:"until"`
+	// Whether it limits a pool of bots rather than a single bot
+	Pool bool `json:"pool,omitempty"`
+	// Usernames and wildcard patterns of the bots of the pool
+	Patterns []string `json:"patterns,omitempty"`
+	// Limit of each user of the bot, zero if there is none
+	UserCooldownMinutes int `json:"usercooldownminutes,omitempty"`
+	UserBurnoutLimit    int `json:"userburnoutlimit,omitempty"`
+	// Counters of the per-user limit by user id
+	UserCounters map[string]*Counter `json:"usercounters,omitempty"`
 }
 
+// gMainTier names the BurnoutLimit and CooldownMinutes of GroupSetup among its tiers
+const gMainTier = "main"
+
+// gCustomTier names the custom limit of a member among its tiers
+const gCustomTier = "custom"
+
+// gNewcomerTier names the newcomer limit among the tiers
+const gNewcomerTier = "newcomer"
+
 var gDefaultSetup = GroupSetup{
 	CooldownMinutes: 240,
 	BurnoutLimit:    4,
+	Mode:            ModeFixed,
 }
 
 type GroupStat struct {
@@ -42,12 +103,28 @@ type GroupStat struct {
 	Setup       GroupSetup `json:"setup"`
 	Users       []User     `json:"users"`
 	BotsSetup   []BotSetup `json:"botsetup"`
-}
+	Pools       []BotSetup `json:"pools,omitempty"`
+	Members     []Member   `json:"members,omitempty"`
+	// Messages of limited content classes blocked, apart from the inline messages
+	ContentBlockCount int `json:"contentblockcount,omitempty"`
+	// Messages that would have been blocked while in dry run
+	WouldBlockCount int `json:"wouldblockcount,omitempty"`
+	// Dry run state, nil if the group is enforced
+	DryRun *DryRun `json:"dryrun,omitempty"`
+	// Every inline message is deleted until then
+	LockdownUntil time.Time `json:"lockdownuntil"`
+	// Temporary user limit, nil if the setup is in effect
+	Relax *Relaxation `json:"relax,omitempty"`
+	// Policies of bots by username
+	BotPolicies map[string]string `json:"botpolicies,omitempty"`
 
-var groups []GroupStat
+	mu sync.Mutex
+	// Telegram calls queued by later, run once the group is released
+	pending []func()
+}
 
-func newGroup(gid string) GroupStat {
-	return GroupStat{
+func newGroup(gid string) *GroupStat {
+	return &GroupStat{
 		Id:          gid,
 		InlineCount: 0,
 		ChatCount:   0,
@@ -56,23 +133,24 @@ func newGroup(gid string) GroupStat {
 		Users:       make([]User, 0),
 	}
 }
-func findGroupByGid(gid string) int {
-	for k, v := range groups {
-		if v.Id == gid {
-			return k
-		}
-	}
-	groups = append(groups, newGroup(gid))
-	return len(groups) - 1
-}
 
 func (g *GroupStat) NewUser(id string) {
-	g.Users = append(g.Users, User{Id: id, Count: 0, Cooldown: g.Setup.CooldownMinutes})
+	g.Users = append(g.Users, User{Id: id})
 	log.Debug(fmt.Sprintf("NewUser: %s, %d", id, g.Setup.CooldownMinutes))
 }
 func (g *GroupStat) GetUser(id string) *User {
 	return &g.Users[g.FindUser(id)]
 }
+
+// lookupUser returns the user of the id, nil if the user sent nothing since the last heatsink.
+func (g *GroupStat) lookupUser(id string) *User {
+	for i, v := range g.Users {
+		if v.Id == id {
+			return &g.Users[i]
+		}
+	}
+	return nil
+}
 func (g *GroupStat) GetBotSetup(name string) *BotSetup {
 	for i, v := range g.BotsSetup {
 		if v.Id == name {
@@ -82,7 +160,7 @@ func (g *GroupStat) GetBotSetup(name string) *BotSetup {
 	return nil
 }
 func (g *GroupStat) NewBotSetup(name string, cooldown int, burnout int) int {
-	g.BotsSetup = append(g.BotsSetup, BotSetup{User: User{Id: name, Count: 0, Cooldown: 0}, GroupSetup: GroupSetup{CooldownMinutes: cooldown, BurnoutLimit: burnout}})
+	g.BotsSetup = append(g.BotsSetup, BotSetup{User: User{Id: name}, GroupSetup: GroupSetup{CooldownMinutes: cooldown, BurnoutLimit: burnout, Mode: g.Setup.Mode}})
 	return len(g.BotsSetup) - 1
 }
 func (g *GroupStat) RemoveBotSetup(name string) {
@@ -93,6 +171,20 @@ func (g *GroupStat) RemoveBotSetup(name string) {
 		}
 	}
 }
+
+// BotSetupRoutine removes the bot limits whose time is up, keeping the per-user limits of the bots.
+func (g *GroupStat) BotSetupRoutine(now time.Time) {
+	for i := len(g.BotsSetup) - 1; i >= 0; i-- {
+		if bs := &g.BotsSetup[i]; !bs.Until.IsZero() && !now.Before(bs.Until) {
+			timerLog.Info("[BOTLIMIT END]", "detail", fmt.Sprintf("Chat %s\nBot @%s", g.Id, bs.Id))
+			if bs.HasUserLimit() {
+				bs.ClearBotLimit()
+			} else {
+				g.RemoveBotSetup(bs.Id)
+			}
+		}
+	}
+}
 func (g *GroupStat) FindUser(id string) int {
 	for k, v := range g.Users {
 		if v.Id == id {
@@ -111,49 +203,189 @@ func (g *GroupStat) MsgCount(t string) {
 		g.ChatCount++
 	case "block":
 		g.BlockCount++
+	case "contentblock":
+		g.ContentBlockCount++
+	case "wouldblock":
+		g.WouldBlockCount++
 	}
 }
 
 func (g *GroupStat) Heatsink() {
 	g.Users = make([]User, 0)
+	for i := range g.Members {
+		g.Members[i].PenaltyUntil = time.Time{}
+		g.Members[i].Penalty = 0
+		g.Members[i].Offences = 0
+	}
 	for i := range g.BotsSetup {
-		g.BotsSetup[i].Count = 0
+		g.BotsSetup[i].Reset()
 		g.BotsSetup[i].Warned = false
+		g.BotsSetup[i].UserCounters = nil
+	}
+	for i := range g.Pools {
+		g.Pools[i].Reset()
+		g.Pools[i].Warned = false
+		g.Pools[i].UserCounters = nil
+	}
+}
+
+// migrateLegacy converts the cooldowns of data saved by older versions.
+func (g *GroupStat) migrateLegacy(now time.Time) {
+	for i := range g.Users {
+		g.Users[i].migrateLegacy(now, g.Setup.CooldownMinutes)
+	}
+	for i := range g.BotsSetup {
+		g.BotsSetup[i].migrateLegacy(now, g.BotsSetup[i].CooldownMinutes)
+	}
+}
+
+// IsLockedDown reports whether every inline message is deleted at now.
+func (g *GroupStat) IsLockedDown(now time.Time) bool {
+	return now.Before(g.LockdownUntil)
+}
+
+// LockdownRoutine ends the lockdown when its time is up and announces it.
+func (g *GroupStat) LockdownRoutine(now time.Time) {
+	if g.LockdownUntil.IsZero() || g.IsLockedDown(now) {
+		return
 	}
+	g.LockdownUntil = time.Time{}
+	gid, _ := strconv.ParseInt(g.Id, 10, 64)
+	g.later(func() {
+		sendMsg(tele.ChatID(gid), escape("The lockdown is over, inline messages are limited as usual again."))
+	})
+	timerLog.Info("[LOCKDOWN END]", "detail", "Chat "+g.Id)
 }
+
 func (g *GroupStat) StatReset() {
 	g.InlineCount = 0
 	g.ChatCount = 0
 	g.BlockCount = 0
+	g.ContentBlockCount = 0
+	g.WouldBlockCount = 0
+}
+
+// SetMode switches the limit mode of the group and all of its bots.
+func (g *GroupStat) SetMode(mode string) {
+	g.Setup.Mode = mode
+	for i := range g.BotsSetup {
+		g.BotsSetup[i].Mode = mode
+	}
+	for i := range g.Pools {
+		g.Pools[i].Mode = mode
+	}
 }
 
-func (g *GroupStat) UserCountAdd(u *User) {
-	u.Count++
-	if u.Count == 1 {
-		u.Cooldown = g.Setup.CooldownMinutes
+func (g *GroupStat) GetTier(name string) *LimitTier {
+	for i, v := range g.Setup.Tiers {
+		if v.Name == name {
+			return &g.Setup.Tiers[i]
+		}
+	}
+	return nil
+}
+func (g *GroupStat) RemoveTier(name string) bool {
+	for i, v := range g.Setup.Tiers {
+		if v.Name == name {
+			g.Setup.Tiers = append(g.Setup.Tiers[:i], g.Setup.Tiers[i+1:]...)
+			for _, u := range g.Users {
+				delete(u.TierCounters, name)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// TierSetup returns the setup to limit a tier with, in the mode of the group.
+func (g *GroupStat) TierSetup(t LimitTier) GroupSetup {
+	return GroupSetup{CooldownMinutes: t.CooldownMinutes, BurnoutLimit: t.BurnoutLimit, Mode: g.Setup.Mode}
+}
+
+// TierCounter returns the counter of the tier, creating it if needed.
+func (u *User) TierCounter(name string) *Counter {
+	if u.TierCounters == nil {
+		u.TierCounters = make(map[string]*Counter)
+	}
+	if u.TierCounters[name] == nil {
+		u.TierCounters[name] = &Counter{}
+	}
+	return u.TierCounters[name]
+}
+
+// pruneTierCounters forgets the tier counters that cooled down, so idle users do not keep one per tier.
+func (u *User) pruneTierCounters() {
+	for name, c := range u.TierCounters {
+		if c.Count == 0 {
+			delete(u.TierCounters, name)
+		}
+	}
+	if len(u.TierCounters) == 0 {
+		u.TierCounters = nil
+	}
+}
+
+func (g *GroupStat) UserCountAdd(u *User, weight int) {
+	now := time.Now()
+	s, _ := g.UserSetup(u.Id, now)
+	s.AddWeight(&u.Counter, now, weight)
+	for _, t := range s.Tiers {
+		g.TierSetup(t).AddWeight(u.TierCounter(t.Name), now, weight)
+	}
+}
+
+// UserBurnout returns the tier the user burned out and when the next message is allowed.
+// An empty tier means the user is not burned, a zero time means no message is allowed at all.
+func (g *GroupStat) UserBurnout(u *User, now time.Time) (tier string, next time.Time) {
+	return g.WeightedBurnout(u, now, 1)
+}
+
+// WeightedBurnout returns the tier a message costing the weight would burn out and when it will be allowed,
+// an empty tier if the message is allowed.
+func (g *GroupStat) WeightedBurnout(u *User, now time.Time, weight int) (tier string, next time.Time) {
+	check := func(name string, s GroupSetup, c *Counter) {
+		if !s.IsBurnedBy(c, now, weight) || (tier != "" && next.IsZero()) {
+			return
+		}
+		if n := s.NextAllowedBy(c, now, weight); tier == "" || n.IsZero() || n.After(next) {
+			tier, next = name, n
+		}
+	}
+	s, name := g.UserSetup(u.Id, now)
+	check(name, s, &u.Counter)
+	for _, t := range s.Tiers {
+		check(t.Name, g.TierSetup(t), u.TierCounter(t.Name))
+	}
+	if until, _ := g.EscalatedUntil(u.Id, now); !until.IsZero() && (tier == "" || (!next.IsZero() && until.After(next))) {
+		if tier == "" {
+			tier = name
+		}
+		next = until
 	}
+	return
 }
 func (g *GroupStat) IsUserBurned(u *User) bool {
-	return u.Count >= g.Setup.BurnoutLimit
+	tier, _ := g.UserBurnout(u, time.Now())
+	return tier != ""
 }
 
 func (b *BotSetup) CountAdd() {
-	b.Count++
-	if b.Count == 1 {
-		b.Cooldown = b.CooldownMinutes
+	if b.HasBotLimit() {
+		b.Add(&b.Counter, time.Now())
 	}
 }
 
 func (g *GroupStat) IsBotBurned(name string) bool {
-	bk := g.GetBotSetup(name)
+	bk := g.ResolveBotSetup(name)
 	if bk == nil {
 		return false
 	}
-	return bk.Count >= bk.BurnoutLimit
+	botRefresh(bk, time.Now())
+	return bk.HasBotLimit() && bk.Count >= bk.BurnoutLimit
 }
 
 func (g *GroupStat) BotWarn(name string) bool {
-	bk := g.GetBotSetup(name)
+	bk := g.ResolveBotSetup(name)
 	if bk == nil {
 		return true
 	}
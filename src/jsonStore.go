@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// jsonStore keeps each document in a JSON file under dir/data, and the events as JSON lines.
+type jsonStore struct {
+	dir string
+	// guards the events file
+	mu sync.Mutex
+}
+
+func newJSONStore(dir string) *jsonStore {
+	return &jsonStore{dir: dir}
+}
+
+func (s *jsonStore) path(name string) string {
+	return filepath.Join(s.dir, "data", name)
+}
+
+// readJSON reads the file into v, leaving v as is if the file does not exist.
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// groupsDoc is inline.json, the groups with the schema version of their data, written at once.
+// Older versions saved the bare list, with the version apart in schema.json.
+type groupsDoc struct {
+	Version int          `json:"version"`
+	Groups  []*GroupStat `json:"groups"`
+}
+
+// loadGroupsDoc reads inline.json in either layout.
+func (s *jsonStore) loadGroupsDoc() (groupsDoc, error) {
+	doc := groupsDoc{Groups: make([]*GroupStat, 0)}
+	data, err := os.ReadFile(s.path("inline.json"))
+	if os.IsNotExist(err) {
+		return doc, nil
+	}
+	if err != nil {
+		return doc, err
+	}
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		var legacy schemaDoc
+		if err := json.Unmarshal(data, &doc.Groups); err != nil {
+			return doc, err
+		}
+		err = readJSON(s.path("schema.json"), &legacy)
+		doc.Version = legacy.Version
+		return doc, err
+	}
+	err = json.Unmarshal(data, &doc)
+	if doc.Groups == nil {
+		doc.Groups = make([]*GroupStat, 0)
+	}
+	return doc, err
+}
+
+func (s *jsonStore) LoadGroups() ([]*GroupStat, error) {
+	doc, err := s.loadGroupsDoc()
+	return doc.Groups, err
+}
+func (s *jsonStore) SaveGroups(groups []*GroupStat, version int) error {
+	if err := writeAtomic(s.path("inline.json"), groupsDoc{Version: version, Groups: groups}); err != nil {
+		return err
+	}
+	// the version of the older layout is superseded
+	if err := os.Remove(s.path("schema.json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+func (s *jsonStore) LoadPendingDeletes() ([]MsgWithTimeout, error) {
+	msgs := make([]MsgWithTimeout, 0)
+	err := readJSON(s.path("msg2delete.json"), &msgs)
+	return msgs, err
+}
+func (s *jsonStore) SavePendingDeletes(msgs []MsgWithTimeout) error {
+	return writeAtomic(s.path("msg2delete.json"), msgs)
+}
+func (s *jsonStore) LoadBotStat() (BotStat, error) {
+	var stat BotStat
+	err := readJSON(s.path("bot.json"), &stat)
+	return stat, err
+}
+func (s *jsonStore) SaveBotStat(stat BotStat) error {
+	return writeAtomic(s.path("bot.json"), stat)
+}
+
+// schemaDoc is schema.json, the schema version saved apart from the groups by older versions.
+type schemaDoc struct {
+	Version int `json:"version"`
+}
+
+func (s *jsonStore) LoadSchemaVersion() (int, error) {
+	doc, err := s.loadGroupsDoc()
+	return doc.Version, err
+}
+
+// Backup copies the documents, but not the events which are never rewritten, into data/backup.
+func (s *jsonStore) Backup(version int) (string, error) {
+	dir := filepath.Join(s.dir, "data", "backup", fmt.Sprintf("v%d-%s", version, time.Now().Format(gBackupTimeFormat)))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	for _, name := range []string{"inline.json", "msg2delete.json", "bot.json", "schema.json"} {
+		data, err := os.ReadFile(s.path(name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func (s *jsonStore) AppendEvents(events []Event) error {
+	var data []byte
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Join(s.dir, "data"), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Stat(s.path("events.jsonl")); err == nil && info.Size()+int64(len(data)) >= gEventLogMaxSize {
+		if err := s.rotateEvents(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(s.path("events.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// eventsFile names the rotated event logs, events.jsonl.1 being the newest of them.
+func (s *jsonStore) eventsFile(i int) string {
+	if i == 0 {
+		return s.path("events.jsonl")
+	}
+	return s.path(fmt.Sprintf("events.jsonl.%d", i))
+}
+
+// rotateEvents shifts the event logs by one, dropping the oldest.
+func (s *jsonStore) rotateEvents() error {
+	if err := os.Remove(s.eventsFile(gEventLogFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := gEventLogFiles - 1; i >= 0; i-- {
+		if err := os.Rename(s.eventsFile(i), s.eventsFile(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonStore) EachEvent(fn func(e Event) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := gEventLogFiles; i >= 0; i-- {
+		if err := eachEventIn(s.eventsFile(i), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eachEventIn(path string, fn func(e Event) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *jsonStore) Close() error {
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Ladder actions, from the mildest to the most severe
+const (
+	// ActionWarn keeps the message and warns the sender.
+	ActionWarn = "warn"
+	// ActionDelete deletes the message, or restricts the sender in restrict enforcement.
+	ActionDelete = "delete"
+	// ActionMute deletes the message and takes all rights to send messages from the sender for a while.
+	ActionMute = "mute"
+	// ActionBan deletes the message and bans the sender.
+	ActionBan = "ban"
+)
+
+var gLadderActions = []string{ActionWarn, ActionDelete, ActionMute, ActionBan}
+
+// Offences counted by ladder steps
+const (
+	// PerMsg counts the messages over the limit since the last burnout.
+	PerMsg = "msg"
+	// PerBurnout counts the burnouts in the last gLadderBurnoutWindow.
+	PerBurnout = "burnout"
+)
+
+var gLadderBurnoutWindow = 24 * time.Hour
+var gLadderHistoryMax = 20
+var gLadderHistoryAge = 30 * 24 * time.Hour
+
+// LadderStep applies its action from the At-th offence on.
+type LadderStep struct {
+	At     int
+	Per    string
+	Action string
+	// Duration of the mute
+	Minutes int `json:",omitempty"`
+}
+
+func (l LadderStep) String() string {
+	text := fmt.Sprintf("from %s #%d: %s", l.Per, l.At, l.Action)
+	if l.Action == ActionMute {
+		text += fmt.Sprintf(" for %d minutes", l.Minutes)
+	}
+	return text
+}
+
+// ActionRecord is a ladder step taken against a member.
+type ActionRecord struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Minutes int       `json:"minutes,omitempty"`
+}
+
+func severity(action string) int {
+	for i, v := range gLadderActions {
+		if v == action {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s GroupSetup) hasBurnoutSteps() bool {
+	for _, l := range s.Ladder {
+		if l.Per == PerBurnout {
+			return true
+		}
+	}
+	return false
+}
+
+// LadderStep counts a message over the limit of the user and returns the most severe step it reaches.
+// Without a ladder, or below its first step, messages over the limit are deleted.
+func (g *GroupStat) LadderStep(id string, now time.Time) LadderStep {
+	m := g.NewMember(id)
+	m.Offences++
+	step := LadderStep{Action: ActionDelete}
+	if len(g.Setup.Ladder) == 0 {
+		return step
+	}
+	step.Action = ""
+	burnouts := m.BurnoutsWithin(now, gLadderBurnoutWindow)
+	for _, l := range g.Setup.Ladder {
+		reached := (l.Per == PerMsg && m.Offences >= l.At) || (l.Per == PerBurnout && burnouts >= l.At)
+		if reached && severity(l.Action) > severity(step.Action) {
+			step = l
+		}
+	}
+	if step.Action == "" {
+		step.Action = ActionDelete
+	}
+	return step
+}
+
+// RecordAction keeps a ladder step taken against the member for the admins to review.
+func (g *GroupStat) RecordAction(id string, step LadderStep, now time.Time) {
+	m := g.NewMember(id)
+	m.Actions = append(m.Actions, ActionRecord{Time: now, Action: step.Action, Minutes: step.Minutes})
+	if len(m.Actions) > gLadderHistoryMax {
+		m.Actions = m.Actions[len(m.Actions)-gLadderHistoryMax:]
+	}
+}
+
+// pruneActions forgets the ladder steps older than gLadderHistoryAge.
+func (m *Member) pruneActions(now time.Time) {
+	i := 0
+	for i < len(m.Actions) && now.Sub(m.Actions[i].Time) > gLadderHistoryAge {
+		i++
+	}
+	m.Actions = m.Actions[i:]
+	if len(m.Actions) == 0 {
+		m.Actions = nil
+	}
+}
+
+// MuteUser takes all rights to send messages from the user for the given minutes, once the group is released.
+func (g *GroupStat) MuteUser(chat *tele.Chat, user *tele.User, minutes int) bool {
+	until := time.Now().Add(time.Duration(minutes) * time.Minute)
+	if !canRestrict(chat) {
+		return false
+	}
+	m := g.NewMember(strconv.FormatInt(user.ID, 10))
+	m.Name = fullName(user)
+	m.MutedUntil = until
+	// the mute covers the inline restriction
+	m.RestrictedUntil = time.Time{}
+	g.later(func() {
+		err := bot.Restrict(chat, &tele.ChatMember{User: user, Rights: tele.NoRights(), RestrictedUntil: until.Unix()})
+		if err != nil {
+			errLog.Error("Mute member", "chat", chat.ID, "user", user.ID, "err", err)
+		}
+	})
+	return true
+}
+
+// BanUser bans the user from the chat, once the group is released.
+func (g *GroupStat) BanUser(chat *tele.Chat, user *tele.User) bool {
+	if !canRestrict(chat) {
+		return false
+	}
+	g.later(func() {
+		err := bot.Ban(chat, &tele.ChatMember{User: user, RestrictedUntil: tele.Forever()})
+		if err != nil {
+			errLog.Error("Ban member", "chat", chat.ID, "user", user.ID, "err", err)
+		}
+	})
+	return true
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"time"
+)
+
+// Limit modes of GroupSetup
+const (
+	// ModeFixed starts a window on the first message and resets the count when the window expires.
+	ModeFixed = "fixed"
+	// ModeSliding allows a message only if fewer than BurnoutLimit messages were sent in the last CooldownMinutes.
+	ModeSliding = "sliding"
+	// ModeBucket refills one message every CooldownMinutes/BurnoutLimit minutes, up to BurnoutLimit.
+	ModeBucket = "bucket"
+)
+
+// Counter is the state of a rate limit window measured by the wall clock,
+// so cooldowns keep running while the bot is offline.
+type Counter struct {
+	// Count of valid inline messages
+	Count int `json:"count"`
+	// When the first message of the window was sent, or the last refill in bucket mode
+	WindowStart time.Time `json:"windowstart"`
+	// When the window ends, the oldest message leaves it in sliding mode,
+	// or the next message refills in bucket mode
+	Expiry time.Time `json:"expiry"`
+	// Times of the messages in the window, only used in sliding mode
+	History []time.Time `json:"history,omitempty"`
+	// Cooldown time left in minutes, only kept to migrate old data
+	LegacyCooldown int `json:"cooldown,omitempty"`
+}
+
+func (c *Counter) Reset() {
+	c.Count = 0
+	c.WindowStart = time.Time{}
+	c.Expiry = time.Time{}
+	c.History = nil
+}
+
+// migrateLegacy converts the old countdown in minutes into absolute window times.
+func (c *Counter) migrateLegacy(now time.Time, cooldownMinutes int) {
+	if !c.Expiry.IsZero() {
+		return
+	}
+	if c.LegacyCooldown > 0 && c.Count > 0 {
+		c.Expiry = now.Add(time.Duration(c.LegacyCooldown) * time.Minute)
+		c.WindowStart = c.Expiry.Add(-time.Duration(cooldownMinutes) * time.Minute)
+	} else if c.Count > 0 {
+		c.Reset()
+	}
+	c.LegacyCooldown = 0
+}
+
+func (s GroupSetup) cooldown() time.Duration {
+	return time.Duration(s.CooldownMinutes) * time.Minute
+}
+
+// refillInterval returns the time to refill one message in bucket mode.
+func (s GroupSetup) refillInterval() time.Duration {
+	if s.BurnoutLimit <= 0 {
+		return 0
+	}
+	return s.cooldown() / time.Duration(s.BurnoutLimit)
+}
+
+// ModeName returns the mode for display, data saved by older versions has no mode.
+func (s GroupSetup) ModeName() string {
+	if s.Mode == "" {
+		return ModeFixed
+	}
+	return s.Mode
+}
+
+// Refresh brings the counter up to date with the clock and the setup,
+// in case of setup changed. It returns true when the count drops to zero.
+func (s GroupSetup) Refresh(c *Counter, now time.Time) bool {
+	if c.Count == 0 {
+		return false
+	}
+	switch s.Mode {
+	case ModeSliding:
+		cut := now.Add(-s.cooldown())
+		i := 0
+		for i < len(c.History) && !c.History[i].After(cut) {
+			i++
+		}
+		c.History = c.History[i:]
+		c.Count = len(c.History)
+		if c.Count == 0 {
+			c.Reset()
+			return true
+		}
+		c.WindowStart = c.History[0]
+		c.Expiry = c.WindowStart.Add(s.cooldown())
+		return false
+	case ModeBucket:
+		interval := s.refillInterval()
+		if interval <= 0 {
+			c.Reset()
+			return true
+		}
+		if c.Count > s.BurnoutLimit {
+			c.Count = s.BurnoutLimit
+		}
+		n := int(now.Sub(c.WindowStart) / interval)
+		if n > 0 {
+			c.Count -= n
+			if c.Count <= 0 {
+				c.Reset()
+				return true
+			}
+			c.WindowStart = c.WindowStart.Add(time.Duration(n) * interval)
+		}
+		c.Expiry = c.WindowStart.Add(interval)
+		return false
+	default:
+		if end := c.WindowStart.Add(s.cooldown()); c.Expiry.After(end) {
+			c.Expiry = end
+		}
+		if now.Before(c.Expiry) {
+			return false
+		}
+		c.Reset()
+		return true
+	}
+}
+
+func (s GroupSetup) Add(c *Counter, now time.Time) {
+	s.AddWeight(c, now, 1)
+}
+
+// AddWeight counts a message costing the weight, as many messages sent at now in sliding mode.
+func (s GroupSetup) AddWeight(c *Counter, now time.Time, weight int) {
+	s.Refresh(c, now)
+	weight = s.capWeight(weight)
+	started := c.Count == 0
+	switch s.Mode {
+	case ModeSliding:
+		for i := 0; i < weight; i++ {
+			c.History = append(c.History, now)
+		}
+		c.Count = len(c.History)
+		c.WindowStart = c.History[0]
+		c.Expiry = c.WindowStart.Add(s.cooldown())
+	case ModeBucket:
+		c.Count += weight
+		if started {
+			c.WindowStart = now
+			c.Expiry = now.Add(s.refillInterval())
+		}
+	default:
+		c.Count += weight
+		if started {
+			c.WindowStart = now
+			c.Expiry = now.Add(s.cooldown())
+		}
+	}
+}
+
+// capWeight keeps a message from costing more than the whole limit, so a heavy message is never blocked forever.
+func (s GroupSetup) capWeight(weight int) int {
+	if weight > s.BurnoutLimit {
+		weight = s.BurnoutLimit
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	return weight
+}
+
+func (s GroupSetup) IsBurned(c *Counter, now time.Time) bool {
+	return s.IsBurnedBy(c, now, 1)
+}
+
+// IsBurnedBy reports whether a message costing the weight would go over the limit.
+func (s GroupSetup) IsBurnedBy(c *Counter, now time.Time, weight int) bool {
+	s.Refresh(c, now)
+	return c.Count+s.capWeight(weight) > s.BurnoutLimit
+}
+
+// Remaining returns how much of the limit is left.
+func (s GroupSetup) Remaining(c *Counter, now time.Time) int {
+	s.Refresh(c, now)
+	if c.Count >= s.BurnoutLimit {
+		return 0
+	}
+	return s.BurnoutLimit - c.Count
+}
+
+// NextAllowed returns when the next message will be allowed.
+// The zero time means no message is allowed at all.
+func (s GroupSetup) NextAllowed(c *Counter, now time.Time) time.Time {
+	return s.NextAllowedBy(c, now, 1)
+}
+
+// NextAllowedBy returns when a message costing the weight will be allowed.
+func (s GroupSetup) NextAllowedBy(c *Counter, now time.Time, weight int) time.Time {
+	if !s.IsBurnedBy(c, now, weight) {
+		return now
+	}
+	if s.BurnoutLimit <= 0 {
+		return time.Time{}
+	}
+	// how much has to leave the window, or to refill in bucket mode
+	over := c.Count + s.capWeight(weight) - s.BurnoutLimit
+	if s.Mode == ModeSliding {
+		// wait until enough messages left the window
+		return c.History[over-1].Add(s.cooldown())
+	}
+	if s.Mode == ModeBucket {
+		return c.Expiry.Add(time.Duration(over-1) * s.refillInterval())
+	}
+	// the end of the window
+	return c.Expiry
+}
+
+// minutesUntil returns the minutes from now to t, rounded up.
+func minutesUntil(t time.Time, now time.Time) int {
+	if !now.Before(t) {
+		return 0
+	}
+	return int((t.Sub(now) + time.Minute - 1) / time.Minute)
+}
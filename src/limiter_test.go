@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func clock(hhmm string) time.Time {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2024, 5, 6, t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func TestLimiterModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup GroupSetup
+		sent  []string
+		at    string
+		// the expected state at the time
+		burned bool
+		next   string
+		count  int
+	}{
+		{"fixed burned in the window", GroupSetup{BurnoutLimit: 4, CooldownMinutes: 240, Mode: ModeFixed}, []string{"10:00", "10:10", "10:20", "10:30"}, "13:59", true, "14:00", 4},
+		{"fixed reset at the end of the window", GroupSetup{BurnoutLimit: 4, CooldownMinutes: 240, Mode: ModeFixed}, []string{"10:00", "10:10", "10:20", "10:30"}, "14:00", false, "14:00", 0},
+		{"no mode is fixed", GroupSetup{BurnoutLimit: 2, CooldownMinutes: 60}, []string{"10:00", "10:50"}, "10:59", true, "11:00", 2},
+		{"sliding waits for the oldest", GroupSetup{BurnoutLimit: 2, CooldownMinutes: 60, Mode: ModeSliding}, []string{"10:00", "10:30"}, "10:45", true, "11:00", 2},
+		{"sliding drops the oldest", GroupSetup{BurnoutLimit: 2, CooldownMinutes: 60, Mode: ModeSliding}, []string{"10:00", "10:30"}, "11:00", false, "11:00", 1},
+		{"sliding empties", GroupSetup{BurnoutLimit: 2, CooldownMinutes: 60, Mode: ModeSliding}, []string{"10:00", "10:30"}, "11:30", false, "11:30", 0},
+		{"bucket waits for a refill", GroupSetup{BurnoutLimit: 4, CooldownMinutes: 240, Mode: ModeBucket}, []string{"10:00", "10:00", "10:00", "10:00"}, "10:30", true, "11:00", 4},
+		{"bucket refills one per interval", GroupSetup{BurnoutLimit: 4, CooldownMinutes: 240, Mode: ModeBucket}, []string{"10:00", "10:00", "10:00", "10:00"}, "12:30", false, "12:30", 2},
+		{"bucket refills fully", GroupSetup{BurnoutLimit: 4, CooldownMinutes: 240, Mode: ModeBucket}, []string{"10:00", "10:00", "10:00", "10:00"}, "14:00", false, "14:00", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Counter{}
+			for _, at := range tt.sent {
+				tt.setup.Add(c, clock(at))
+			}
+			now := clock(tt.at)
+			if got := tt.setup.IsBurned(c, now); got != tt.burned {
+				t.Errorf("IsBurned = %v, want %v", got, tt.burned)
+			}
+			if got := tt.setup.NextAllowed(c, now); !got.Equal(clock(tt.next)) {
+				t.Errorf("NextAllowed = %s, want %s", got.Format("15:04"), tt.next)
+			}
+			if c.Count != tt.count {
+				t.Errorf("Count = %d, want %d", c.Count, tt.count)
+			}
+		})
+	}
+}
+
+func TestLimiterWeights(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  GroupSetup
+		weight int
+		sent   []string
+		at     string
+		burned bool
+		next   string
+	}{
+		{"fixed heavy message over the rest", GroupSetup{BurnoutLimit: 4, CooldownMinutes: 60, Mode: ModeFixed}, 3, []string{"10:00", "10:10"}, "10:20", true, "11:00"},
+		{"fixed heavy message within the rest", GroupSetup{BurnoutLimit: 4, CooldownMinutes: 60, Mode: ModeFixed}, 2, []string{"10:00", "10:10"}, "10:20", false, "10:20"},
+		{"sliding heavy message waits for enough to leave", GroupSetup{BurnoutLimit: 3, CooldownMinutes: 60, Mode: ModeSliding}, 2, []string{"10:00", "10:10", "10:20"}, "10:30", true, "11:10"},
+		{"bucket heavy message waits for enough refills", GroupSetup{BurnoutLimit: 4, CooldownMinutes: 240, Mode: ModeBucket}, 3, []string{"10:00", "10:00", "10:00", "10:00"}, "10:30", true, "13:00"},
+		{"weight over the limit costs the whole limit", GroupSetup{BurnoutLimit: 2, CooldownMinutes: 60, Mode: ModeSliding}, 10, nil, "10:00", false, "10:00"},
+		{"weight over the limit waits for an empty window", GroupSetup{BurnoutLimit: 2, CooldownMinutes: 60, Mode: ModeSliding}, 10, []string{"10:00"}, "10:30", true, "11:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Counter{}
+			for _, at := range tt.sent {
+				tt.setup.Add(c, clock(at))
+			}
+			now := clock(tt.at)
+			if got := tt.setup.IsBurnedBy(c, now, tt.weight); got != tt.burned {
+				t.Errorf("IsBurnedBy = %v, want %v", got, tt.burned)
+			}
+			if got := tt.setup.NextAllowedBy(c, now, tt.weight); !got.Equal(clock(tt.next)) {
+				t.Errorf("NextAllowedBy = %s, want %s", got.Format("15:04"), tt.next)
+			}
+		})
+	}
+}
+
+func TestAddWeightCaps(t *testing.T) {
+	for _, mode := range []string{ModeFixed, ModeSliding, ModeBucket} {
+		s := GroupSetup{BurnoutLimit: 3, CooldownMinutes: 60, Mode: mode}
+		c := &Counter{}
+		s.AddWeight(c, clock("10:00"), 10)
+		if c.Count != 3 {
+			t.Errorf("%s: Count = %d after a weight of 10, want the limit 3", mode, c.Count)
+		}
+		if mode == ModeSliding && len(c.History) != 3 {
+			t.Errorf("sliding: History has %d entries, want 3", len(c.History))
+		}
+	}
+}
+
+func TestNextAllowedWithoutLimit(t *testing.T) {
+	s := GroupSetup{BurnoutLimit: 0, CooldownMinutes: 60}
+	if got := s.NextAllowed(&Counter{}, clock("10:00")); !got.IsZero() {
+		t.Errorf("NextAllowed = %s, want the zero time", got)
+	}
+}
+
+func TestRefreshAfterModeSwitch(t *testing.T) {
+	// a sliding window of 4 hours seen as a fixed window of 1 hour ends an hour after its start
+	sliding := GroupSetup{BurnoutLimit: 4, CooldownMinutes: 240, Mode: ModeSliding}
+	c := &Counter{}
+	sliding.Add(c, clock("10:00"))
+	sliding.Add(c, clock("10:30"))
+	fixed := GroupSetup{BurnoutLimit: 4, CooldownMinutes: 60, Mode: ModeFixed}
+	if fixed.Refresh(c, clock("10:59")) || c.Count != 2 {
+		t.Fatalf("reset before the end of the fixed window, Count = %d", c.Count)
+	}
+	if !fixed.Refresh(c, clock("11:00")) || c.Count != 0 {
+		t.Fatalf("no reset at the end of the fixed window, Count = %d", c.Count)
+	}
+
+	// a count over a lowered bucket limit is capped to it
+	bucket := GroupSetup{BurnoutLimit: 4, CooldownMinutes: 240, Mode: ModeBucket}
+	c = &Counter{}
+	for i := 0; i < 4; i++ {
+		bucket.Add(c, clock("10:00"))
+	}
+	lowered := GroupSetup{BurnoutLimit: 2, CooldownMinutes: 120, Mode: ModeBucket}
+	lowered.Refresh(c, clock("10:30"))
+	if c.Count != 2 {
+		t.Fatalf("Count = %d over the lowered limit, want 2", c.Count)
+	}
+
+	// a fixed counter has no history, so it starts over in sliding mode
+	c = &Counter{}
+	fixed.Add(c, clock("10:00"))
+	sliding.Refresh(c, clock("10:10"))
+	if c.Count != 0 {
+		t.Fatalf("Count = %d in sliding mode without history, want 0", c.Count)
+	}
+}
+
+func TestCounterMigrateLegacy(t *testing.T) {
+	now := clock("10:00")
+	c := &Counter{Count: 2, LegacyCooldown: 30}
+	c.migrateLegacy(now, 240)
+	if !c.Expiry.Equal(clock("10:30")) || !c.WindowStart.Equal(clock("06:30")) || c.LegacyCooldown != 0 {
+		t.Errorf("window %s-%s, want 06:30-10:30", c.WindowStart.Format("15:04"), c.Expiry.Format("15:04"))
+	}
+	c = &Counter{Count: 2}
+	if c.migrateLegacy(now, 240); c.Count != 0 {
+		t.Errorf("a count without a cooldown is kept, Count = %d", c.Count)
+	}
+}
+
+func TestMinutesUntil(t *testing.T) {
+	now := clock("10:00")
+	for _, tt := range []struct {
+		t    time.Time
+		want int
+	}{
+		{now, 0},
+		{now.Add(-time.Minute), 0},
+		{now.Add(time.Second), 1},
+		{now.Add(time.Minute), 1},
+		{now.Add(61 * time.Second), 2},
+	} {
+		if got := minutesUntil(tt.t, now); got != tt.want {
+			t.Errorf("minutesUntil(%s) = %d, want %d", tt.t.Sub(now), got, tt.want)
+		}
+	}
+}
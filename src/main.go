@@ -5,6 +5,7 @@ import (
 	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"syscall"
 	"time"
@@ -14,7 +15,6 @@ import (
 	kuma "github.com/Nigh/kuma-push"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
-	scribble "github.com/nanobox-io/golang-scribble"
 	tele "gopkg.in/telebot.v3"
 )
 
@@ -35,26 +35,49 @@ type BotStat struct {
 var botStat BotStat
 
 var (
-	gRootID                int64
-	gKumaPushURL           string
-	gToken                 string
-	gCooldownMinutesMin    int           = 5
-	gCooldownMinutesMax    int           = 1440
-	gBurnoutLimitMin       int           = 0
-	gBurnoutLimitMax       int           = 13
-	gWarningTimeout        time.Duration = 15 * time.Second
-	gBotCooldownMinutesMin int           = 1
-	gBotCooldownMinutesMax int           = 1440
-	gBotBurnoutLimitMin    int           = 1
-	gBotBurnoutLimitMax    int           = 1440
+	gRootID                  int64
+	gKumaPushURL             string
+	gToken                   string
+	gCooldownMinutesMin      int           = 5
+	gCooldownMinutesMax      int           = 1440
+	gBurnoutLimitMin         int           = 0
+	gBurnoutLimitMax         int           = 13
+	gWarningTimeout          time.Duration = 15 * time.Second
+	gBotCooldownMinutesMin   int           = 1
+	gBotCooldownMinutesMax   int           = 1440
+	gBotBurnoutLimitMin      int           = 1
+	gBotBurnoutLimitMax      int           = 1440
+	gTiersMax                int           = 5
+	gSchedulesMax            int           = 10
+	gNewcomerHoursMin        int           = 1
+	gNewcomerHoursMax        int           = 168
+	gEscalationFactorMin     int           = 2
+	gEscalationFactorMax     int           = 10
+	gEscalationMultiplierMax int           = 64
+	gEscalationHoursMin      int           = 1
+	gEscalationHoursMax      int           = 168
+	gLadderStepsMax          int           = 10
+	gLadderAtMin             int           = 1
+	gLadderAtMax             int           = 100
+	gMuteMinutesMin          int           = 1
+	gMuteMinutesMax          int           = 10080
+	gLockdownMinutesMin      int           = 1
+	gLockdownMinutesMax      int           = 1440
+	gRelaxMinutesMin         int           = 1
+	gRelaxMinutesMax         int           = 10080
+	gBotLimitMinutesMin      int           = 1
+	gBotLimitMinutesMax      int           = 10080
+	gWeightMin               int           = 1
+	gWeightMax               int           = 10
 )
 
 var bot *tele.Bot
-var db *scribble.Driver
 
 func msgHandler(c tele.Context) error {
 	if c.Message().Via != nil {
 		return inlineMessageHandler(c)
+	} else if class := contentClass(c.Message()); class != "" && findGroupByContext(c).GetContentLimit(class) != nil {
+		return contentMessageHandler(c, class)
 	} else {
 		return chatMessageHandler(c)
 	}
@@ -65,58 +88,82 @@ func oneMinuteTimer() {
 	defer interval.Stop()
 	for range interval.C {
 		inlineCooldownRoutine()
+		markDirty()
 		summaryRoutine()
 	}
 }
 func inlineCooldownRoutine() {
-	for gk, group := range groups {
-		for uk, user := range group.Users {
-			if user.Cooldown > 0 {
-				// in case of setup changed
-				if user.Cooldown > group.Setup.CooldownMinutes {
-					user.Cooldown = group.Setup.CooldownMinutes
+	now := time.Now()
+	groups.Each(func(group *GroupStat) {
+		for uk := range group.Users {
+			user := &group.Users[uk]
+			setup, _ := group.UserSetup(user.Id, now)
+			if setup.Refresh(&user.Counter, now) {
+				timerLog.Info("[COOLDOWN]", "detail", fmt.Sprintf("Chat %s\nUser @%s", group.Id, user.Id))
+			}
+			for _, t := range setup.Tiers {
+				if c := user.TierCounters[t.Name]; c != nil && group.TierSetup(t).Refresh(c, now) {
+					timerLog.Info("[COOLDOWN]", "detail", fmt.Sprintf("Chat %s\nUser @%s\nTier %s", group.Id, user.Id, t.Name))
 				}
-				user.Cooldown--
-				if user.Cooldown <= 0 {
-					user.Count = 0
-					timerLog.Info("[COOLDOWN]", "detail", fmt.Sprintf("Chat %s\nUser @%s", group.Id, user.Id))
+			}
+			for _, l := range group.Setup.Contents {
+				if c := user.TierCounters[gContentTierPrefix+l.Name]; c != nil && group.TierSetup(l).Refresh(c, now) {
+					timerLog.Info("[COOLDOWN]", "detail", fmt.Sprintf("Chat %s\nUser @%s\nContent %s", group.Id, user.Id, l.Name))
 				}
-				groups[gk].Users[uk] = user
 			}
+			user.pruneTierCounters()
 		}
+		group.RestrictionRoutine(now)
+		group.MembersRoutine(now)
+		group.LockdownRoutine(now)
+		group.RelaxRoutine(now)
+		group.BotSetupRoutine(now)
 		for bk := range group.BotsSetup {
 			bs := &group.BotsSetup[bk]
-			if bs.Cooldown > 0 {
-				if bs.Cooldown > bs.CooldownMinutes {
-					bs.Cooldown = bs.CooldownMinutes
-				}
-				bs.Cooldown--
-				if bs.Cooldown <= 0 {
-					bs.Count = 0
-					bs.Warned = false
-					timerLog.Info("[COOLDOWN]", "detail", fmt.Sprintf("Chat %s\nBot @%s", group.Id, bs.Id))
-				}
+			if botRefresh(bs, now) {
+				timerLog.Info("[COOLDOWN]", "detail", fmt.Sprintf("Chat %s\nBot @%s", group.Id, bs.Id))
 			}
+			bs.RefreshUsers(now)
 		}
-	}
+		for pk := range group.Pools {
+			pool := &group.Pools[pk]
+			if botRefresh(pool, now) {
+				timerLog.Info("[COOLDOWN]", "detail", fmt.Sprintf("Chat %s\nPool %s", group.Id, pool.Id))
+			}
+			pool.RefreshUsers(now)
+		}
+	})
 }
 func summaryRoutine() {
 	if time.Now().After(botStat.LastSummarySentTime.Add(24*time.Hour)) ||
 		(time.Now().After(botStat.LastSummarySentTime.Add(12*time.Hour)) && time.Now().Hour() >= 23 && time.Now().Minute() >= 30) {
+		hours := int(math.Ceil(time.Since(botStat.LastSummarySentTime).Hours()))
+		botStat.LastSummarySentTime = time.Now()
+		store.SaveBotStat(botStat)
 		go func() {
-			hours := int(math.Ceil(time.Since(botStat.LastSummarySentTime).Hours()))
 			summaryLog.Infof("in %d hours", hours)
-			for k, group := range groups {
-				summaryLog.Infof("[%s] total:%d inline:%d block:%d", group.Id, group.ChatCount+group.InlineCount, group.InlineCount, group.BlockCount)
-				if group.InlineCount > 0 {
+			if dropped := eventsDropped.Swap(0); dropped > 0 {
+				summaryLog.Warnf("%d events dropped on a full queue", dropped)
+			}
+			for _, group := range groups.List() {
+				group.mu.Lock()
+				summaryLog.Infof("[%s] total:%d inline:%d block:%d contentblock:%d wouldblock:%d", group.Id, group.ChatCount+group.InlineCount, group.InlineCount, group.BlockCount, group.ContentBlockCount, group.WouldBlockCount)
+				if group.InlineCount > 0 || group.ContentBlockCount > 0 {
 					gid, _ := strconv.ParseInt(group.Id, 10, 64)
-					sendSelfDestroyMsg(tele.ChatID(gid), fmt.Sprintf("In the past `%d` hours, there are `%d` msgs handled by this bot\\.\nIn the `%d` inline msgs, there are:\n`%d` allowed\n`%d` blocked", hours, group.InlineCount+group.BlockCount+group.ChatCount, group.InlineCount+group.BlockCount, group.InlineCount, group.BlockCount), 6*time.Hour)
+					summary := fmt.Sprintf("In the past `%d` hours, there are `%d` msgs handled by this bot\\.\nIn the `%d` inline msgs, there are:\n`%d` allowed\n`%d` blocked", hours, group.InlineCount+group.BlockCount+group.ChatCount+group.ContentBlockCount, group.InlineCount+group.BlockCount, group.InlineCount, group.BlockCount)
+					if group.IsDryRun() {
+						summary += fmt.Sprintf("\n`%d` would have been blocked \\(dry run\\)", group.WouldBlockCount)
+					}
+					if group.ContentBlockCount > 0 {
+						summary += fmt.Sprintf("\n`%d` sticker, GIF and other limited content msgs were blocked", group.ContentBlockCount)
+					}
+					group.later(func() { sendSelfDestroyMsg(tele.ChatID(gid), summary, 6*time.Hour) })
 				}
-				groups[k].StatReset()
+				group.SendDryRunReport()
+				group.StatReset()
+				group.unlock()
 				time.Sleep(time.Millisecond * 200)
 			}
-			botStat.LastSummarySentTime = time.Now()
-			db.Write("data", "bot", &botStat)
 		}()
 	}
 }
@@ -157,27 +204,44 @@ func logInit() {
 }
 
 func dbInit() {
-	db, _ = scribble.New("../db", nil)
-	db.Read("test", "env", &testEnv)
+	readJSON(filepath.Join(gDBDir, "test", "env.json"), &testEnv)
+	if err := storageInit(); err != nil {
+		errLog.Fatal("Open storage", "err", err)
+	}
 
-	db.Read("data", "bot", &botStat)
+	var err error
+	if botStat, err = store.LoadBotStat(); err != nil {
+		errLog.Fatal("Load bot stat", "err", err)
+	}
 	if botStat.LastSummarySentTime.IsZero() {
 		botStat.LastSummarySentTime = time.Now().Add(-12 * time.Hour)
-		db.Write("data", "bot", &botStat)
+		store.SaveBotStat(botStat)
 	}
 
-	groups = make([]GroupStat, 0)
-	db.Read("data", "inline", &groups)
+	list, err := store.LoadGroups()
+	if err != nil {
+		errLog.Fatal("Load groups", "err", err)
+	}
+	if err := migrateGroups(store, list, time.Now()); err != nil {
+		errLog.Fatal("Migrate data", "err", err)
+	}
 	var setup string
-	for k, v := range groups {
-		if v.Setup.BurnoutLimit == 0 && v.Setup.CooldownMinutes == 0 {
-			groups[k].Setup = gDefaultSetup
+	for _, v := range list {
+		setup += fmt.Sprintf("%s: %d msg in %d min (%s)\n", v.Id, v.Setup.BurnoutLimit, v.Setup.CooldownMinutes, v.Setup.ModeName())
+		for _, t := range v.Setup.Tiers {
+			setup += fmt.Sprintf("    tier %s: %d msg in %d min\n", t.Name, t.BurnoutLimit, t.CooldownMinutes)
+		}
+		for _, r := range v.Setup.Schedules {
+			setup += fmt.Sprintf("    schedule %s\n", r)
 		}
-		setup += fmt.Sprintf("%s: %d msg in %d min\n", v.Id, v.Setup.BurnoutLimit, v.Setup.CooldownMinutes)
 		for _, bot := range v.BotsSetup {
 			setup += fmt.Sprintf("    @%s: %d msg in %d min\n", bot.Id, bot.BurnoutLimit, bot.CooldownMinutes)
 		}
+		for _, pool := range v.Pools {
+			setup += fmt.Sprintf("    pool %s %v: %d msg in %d min\n", pool.Id, pool.Patterns, pool.BurnoutLimit, pool.CooldownMinutes)
+		}
 	}
+	groups.Load(list)
 	log.Info("Read setup", "Groups", setup)
 }
 
@@ -199,13 +263,6 @@ func kumaInit() {
 	k.Start()
 }
 
-func init() {
-	logInit()
-	dbInit()
-	envInit()
-	kumaInit()
-}
-
 func ignoreOldMessages(fn tele.HandlerFunc) tele.HandlerFunc {
 	return func(c tele.Context) error {
 		if c.Message().Unixtime < time.Now().Unix()-60 {
@@ -231,9 +288,13 @@ func privilegeMiddleWare(fn tele.HandlerFunc) tele.HandlerFunc {
 	}
 }
 func main() {
+	logInit()
+	dbInit()
+	envInit()
+	kumaInit()
 	pref := tele.Settings{
 		Token:  gToken,
-		Poller: &tele.LongPoller{Timeout: 2 * time.Second},
+		Poller: &tele.LongPoller{Timeout: 2 * time.Second, AllowedUpdates: []string{"message", "chat_member", "my_chat_member"}},
 	}
 	var err error
 	bot, err = tele.NewBot(pref)
@@ -241,17 +302,25 @@ func main() {
 		errLog.Fatal(err)
 		return
 	}
-	for _, v := range []string{tele.OnText, tele.OnPhoto, tele.OnAnimation, tele.OnDocument, tele.OnSticker, tele.OnVideo, tele.OnVoice} {
-		bot.Handle(v, msgHandler, ignoreOldMessages, privateMiddleWare)
+	for _, v := range []string{tele.OnText, tele.OnPhoto, tele.OnAnimation, tele.OnDocument, tele.OnSticker, tele.OnVideo, tele.OnVoice, tele.OnDice, tele.OnPoll} {
+		bot.Handle(v, msgHandler, ignoreOldMessages, privateMiddleWare, lockGroupMiddleWare)
 	}
-	bot.Handle(cmdHelp, onHelp, ignoreOldMessages, privateMiddleWare, privilegeMiddleWare)
-	bot.Handle(cmdHeatsink, onHeatsink, ignoreOldMessages, privateMiddleWare, privilegeMiddleWare)
+	bot.Handle(cmdHelp, onHelp, ignoreOldMessages, privateMiddleWare, privilegeMiddleWare, lockGroupMiddleWare)
+	bot.Handle(cmdHeatsink, onHeatsink, ignoreOldMessages, privateMiddleWare, privilegeMiddleWare, lockGroupMiddleWare)
+	bot.Handle(cmdExempt, onExempt, ignoreOldMessages, privateMiddleWare, privilegeMiddleWare, lockGroupMiddleWare)
+	bot.Handle(cmdUnexempt, onUnexempt, ignoreOldMessages, privateMiddleWare, privilegeMiddleWare, lockGroupMiddleWare)
+
+	bot.Handle(tele.OnChatMember, onChatMember, lockGroupMiddleWare)
+	bot.Handle(tele.OnMyChatMember, onMyChatMember)
+	bot.Handle(tele.OnUserJoined, onUserJoined, ignoreOldMessages, privateMiddleWare, lockGroupMiddleWare)
 
 	bot.Handle(tele.OnAddedToGroup, func(c tele.Context) error {
 		return c.Send("My pleasure to join the group! Inline messages will be limited by me.")
 	})
 	go bot.Start()
 	go oneMinuteTimer()
+	go snapshotTimer()
+	go eventWriter()
 	msgInit()
 
 	log.Info("online")
@@ -259,13 +328,12 @@ func main() {
 	signal.Notify(sc, os.Interrupt, syscall.SIGTERM)
 	<-sc
 	bot.Stop()
+	flushEvents()
 	log.Info("backup data")
-	err = db.Write("data", "inline", groups)
-	if err != nil {
-		errLog.Error(err)
-	} else {
+	if saveGroups() == nil {
 		log.Info("data backup success")
 	}
+	store.Close()
 	<-time.After(time.Second * 1)
 	log.Info("offline")
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Member keeps the per-user settings of a group, which survive Heatsink.
+type Member struct {
+	Id string `json:"id"`
+	// Name of the user when the member was last updated, for display only
+	Name string `json:"name"`
+	// Exempt members are never limited
+	Exempt bool `json:"exempt,omitempty"`
+	// Custom user limit, replacing the one of the group when set
+	CooldownMinutes int `json:"cooldownminutes,omitempty"`
+	BurnoutLimit    int `json:"burnoutlimit,omitempty"`
+	// When the member joined the group recently, zero if unknown or long ago
+	JoinedAt time.Time `json:"joinedat,omitempty"`
+	// Times of the recent burnouts, for the escalation policy
+	Burnouts []time.Time `json:"burnouts,omitempty"`
+	// End and multiplier of the escalated cooldown
+	PenaltyUntil time.Time `json:"penaltyuntil,omitempty"`
+	Penalty      int       `json:"penalty,omitempty"`
+	// When the restriction of the member ends, zero if not restricted
+	RestrictedUntil time.Time `json:"restricteduntil,omitempty"`
+	// When the mute of the member by the ladder ends, zero if not muted
+	MutedUntil time.Time `json:"muteduntil,omitempty"`
+	// Messages over the limit since the last burnout
+	Offences int `json:"offences,omitempty"`
+	// Ladder steps taken against the member, the latest gLadderHistoryMax ones
+	Actions []ActionRecord `json:"actions,omitempty"`
+}
+
+func (m *Member) HasLimit() bool {
+	return m.CooldownMinutes > 0
+}
+
+// isEmpty reports whether the member has nothing worth keeping.
+func (m *Member) isEmpty() bool {
+	return !m.Exempt && !m.HasLimit() && m.JoinedAt.IsZero() && len(m.Burnouts) == 0 && m.PenaltyUntil.IsZero() &&
+		m.RestrictedUntil.IsZero() && m.MutedUntil.IsZero() && len(m.Actions) == 0
+}
+
+func (g *GroupStat) GetMember(id string) *Member {
+	for i, v := range g.Members {
+		if v.Id == id {
+			return &g.Members[i]
+		}
+	}
+	return nil
+}
+
+// NewMember returns the member of the id, it is created if not exists.
+func (g *GroupStat) NewMember(id string) *Member {
+	if m := g.GetMember(id); m != nil {
+		return m
+	}
+	g.Members = append(g.Members, Member{Id: id})
+	return &g.Members[len(g.Members)-1]
+}
+
+// CleanMembers drops the members with nothing worth keeping.
+func (g *GroupStat) CleanMembers() {
+	members := make([]Member, 0, len(g.Members))
+	for _, m := range g.Members {
+		if !m.isEmpty() {
+			members = append(members, m)
+		}
+	}
+	g.Members = members
+}
+
+func (g *GroupStat) IsUserExempt(id string) bool {
+	m := g.GetMember(id)
+	return m != nil && m.Exempt
+}
+
+// UserJoined records when the user joined, for the newcomer policy.
+// Nothing is recorded without a newcomer policy, every join would add a member otherwise.
+func (g *GroupStat) UserJoined(u *tele.User, at time.Time) {
+	if g.Setup.Newcomer == nil {
+		return
+	}
+	m := g.NewMember(strconv.FormatInt(u.ID, 10))
+	m.Name = fullName(u)
+	m.JoinedAt = at
+}
+
+// ProbationEnd returns when the newcomer probation of the user ends,
+// zero if the user is not on probation at now.
+func (g *GroupStat) ProbationEnd(id string, now time.Time) time.Time {
+	m := g.GetMember(id)
+	if m == nil || m.JoinedAt.IsZero() || g.Setup.Newcomer == nil {
+		return time.Time{}
+	}
+	end := m.JoinedAt.Add(time.Duration(g.Setup.Newcomer.Hours) * time.Hour)
+	if !now.Before(end) {
+		return time.Time{}
+	}
+	return end
+}
+
+// MembersRoutine forgets the join times too old for any newcomer policy
+// and the burnouts out of the lookback of the escalation policy and the ladder.
+func (g *GroupStat) MembersRoutine(now time.Time) {
+	lookback := g.burnoutLookback()
+	for i := range g.Members {
+		m := &g.Members[i]
+		if !m.JoinedAt.IsZero() && now.Sub(m.JoinedAt) > time.Duration(gNewcomerHoursMax)*time.Hour {
+			m.JoinedAt = time.Time{}
+		}
+		m.pruneBurnouts(now, lookback)
+		m.pruneActions(now)
+	}
+	g.CleanMembers()
+}
+
+// UserSetup returns the setup limiting the user at now, and the tier name of its main limit.
+// A custom limit replaces the limit of the group, schedules and tiers included.
+// Otherwise the newcomer policy replaces the main limit during probation.
+// A relaxation replaces the main limit of the schedules.
+func (g *GroupStat) UserSetup(id string, now time.Time) (GroupSetup, string) {
+	s := g.Setup.Active(now)
+	if g.IsRelaxed(now) {
+		s.BurnoutLimit = g.Relax.BurnoutLimit
+		s.CooldownMinutes = g.Relax.CooldownMinutes
+	}
+	if m := g.GetMember(id); m != nil && m.HasLimit() {
+		s.BurnoutLimit = m.BurnoutLimit
+		s.CooldownMinutes = m.CooldownMinutes
+		s.Tiers = nil
+		return s, gCustomTier
+	}
+	if !g.ProbationEnd(id, now).IsZero() {
+		s.BurnoutLimit = g.Setup.Newcomer.BurnoutLimit
+		if g.Setup.Newcomer.Ban {
+			s.BurnoutLimit = 0
+		}
+		return s, gNewcomerTier
+	}
+	return s, gMainTier
+}
+
+func onUserJoined(c tele.Context) error {
+	findGroupByContext(c).UserJoined(c.Message().UserJoined, c.Message().Time())
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Migration upgrades the groups saved with the previous schema version to its Version.
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func(list []*GroupStat, now time.Time) error
+}
+
+// gMigrations runs in order, the last Version is the schema version of this build.
+// Append new migrations at the end, never change or remove the ones already released.
+var gMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "default setup of groups saved without one",
+		Apply: func(list []*GroupStat, now time.Time) error {
+			for _, g := range list {
+				if g.Setup.BurnoutLimit == 0 && g.Setup.CooldownMinutes == 0 {
+					g.Setup = gDefaultSetup
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "cooldown countdowns to window times",
+		Apply: func(list []*GroupStat, now time.Time) error {
+			for _, g := range list {
+				g.migrateLegacy(now)
+			}
+			return nil
+		},
+	},
+}
+
+func schemaVersion() int {
+	return gMigrations[len(gMigrations)-1].Version
+}
+
+// migrateGroups brings the groups loaded from the storage up to the schema version of this build.
+// The old data is backed up first, and the groups and the new version are saved once all migrations ran.
+func migrateGroups(s Storage, list []*GroupStat, now time.Time) error {
+	version, err := s.LoadSchemaVersion()
+	if err != nil {
+		return fmt.Errorf("load schema version: %w", err)
+	}
+	if version > schemaVersion() {
+		return fmt.Errorf("data schema version %d is newer than %d of this build", version, schemaVersion())
+	}
+	if version == schemaVersion() {
+		return nil
+	}
+	if len(list) == 0 {
+		// nothing saved yet to migrate
+		return s.SaveGroups(list, schemaVersion())
+	}
+	backup, err := s.Backup(version)
+	if err != nil {
+		return fmt.Errorf("back up schema version %d: %w", version, err)
+	}
+	log.Info("Backed up data", "version", version, "backup", backup)
+	for _, m := range gMigrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := m.Apply(list, now); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		log.Info("Migrated data", "version", m.Version, "migration", m.Name)
+	}
+	if err := s.SaveGroups(list, schemaVersion()); err != nil {
+		return fmt.Errorf("save migrated groups: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		groups  int
+		failAt  int
+		applied []int
+		// the version stored afterwards
+		want      int
+		backup    bool
+		wantError string
+	}{
+		{name: "unversioned data runs all", version: 0, groups: 1, applied: []int{1, 2, 3}, want: 3, backup: true},
+		{name: "skips the applied ones", version: 1, groups: 1, applied: []int{2, 3}, want: 3, backup: true},
+		{name: "up to date", version: 3, groups: 1, want: 3},
+		{name: "nothing saved yet", version: 0, groups: 0, want: 3},
+		{name: "refuses newer data", version: 4, groups: 1, want: 4, wantError: "newer"},
+		{name: "failure keeps the old data", version: 0, groups: 1, failAt: 2, applied: []int{1}, want: 0, backup: true, wantError: "migration 2 (second)"},
+	}
+	saved := gMigrations
+	defer func() { gMigrations = saved }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var applied []int
+			migration := func(version int) func(list []*GroupStat, now time.Time) error {
+				return func(list []*GroupStat, now time.Time) error {
+					if version == tt.failAt {
+						return errors.New("on purpose")
+					}
+					applied = append(applied, version)
+					for _, g := range list {
+						g.ChatCount = version
+					}
+					return nil
+				}
+			}
+			gMigrations = []Migration{
+				{Version: 1, Name: "first", Apply: migration(1)},
+				{Version: 2, Name: "second", Apply: migration(2)},
+				{Version: 3, Name: "third", Apply: migration(3)},
+			}
+
+			dir := t.TempDir()
+			s := newJSONStore(dir)
+			var list []*GroupStat
+			for i := 0; i < tt.groups; i++ {
+				list = append(list, newGroup(fmt.Sprintf("-100%d", i)))
+			}
+			if err := s.SaveGroups(list, tt.version); err != nil {
+				t.Fatal(err)
+			}
+			old, _ := os.ReadFile(s.path("inline.json"))
+
+			loaded, err := s.LoadGroups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = migrateGroups(s, loaded, time.Now())
+			if tt.wantError == "" && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tt.wantError != "" && (err == nil || !strings.Contains(err.Error(), tt.wantError)) {
+				t.Fatalf("error %v, want one about %q", err, tt.wantError)
+			}
+			if !reflect.DeepEqual(applied, tt.applied) {
+				t.Errorf("applied %v, want %v", applied, tt.applied)
+			}
+			if version, _ := s.LoadSchemaVersion(); version != tt.want {
+				t.Errorf("stored version %d, want %d", version, tt.want)
+			}
+
+			backups, _ := filepath.Glob(filepath.Join(dir, "data", "backup", "*", "inline.json"))
+			if tt.backup != (len(backups) == 1) {
+				t.Fatalf("backups %v, want one: %v", backups, tt.backup)
+			}
+			if tt.backup {
+				if data, _ := os.ReadFile(backups[0]); string(data) != string(old) {
+					t.Errorf("the backup differs from the data before the migration")
+				}
+			}
+			now, _ := os.ReadFile(s.path("inline.json"))
+			if changed := string(now) != string(old); changed != (tt.want != tt.version) {
+				t.Errorf("saved groups changed: %v", changed)
+			}
+		})
+	}
+}
+
+func TestMigrateStorageResumes(t *testing.T) {
+	src := newJSONStore(t.TempDir())
+	dst, err := newBoltStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	events := make([]Event, 600)
+	for i := range events {
+		events[i] = Event{Time: time.Unix(int64(i), 0), Kind: EventAllowed}
+	}
+	if err := src.AppendEvents(events); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.SaveGroups([]*GroupStat{newGroup("-1001")}, 1); err != nil {
+		t.Fatal(err)
+	}
+	// an interrupted run copied some of the events and nothing else
+	if err := dst.AppendEvents(events[:300]); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := dst.LoadGroups(); len(list) != 0 {
+		t.Fatalf("got %d groups before the migration, want none", len(list))
+	}
+
+	if err := migrateStorage(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	dst.EachEvent(func(e Event) error {
+		got = append(got, e.Time.Unix())
+		return nil
+	})
+	if len(got) != len(events) {
+		t.Fatalf("got %d events, want %d", len(got), len(events))
+	}
+	for i, v := range got {
+		if v != int64(i) {
+			t.Fatalf("event %d is %d, want the events once in order", i, v)
+		}
+	}
+	if list, _ := dst.LoadGroups(); len(list) != 1 {
+		t.Errorf("got %d groups, want 1", len(list))
+	}
+	if version, _ := dst.LoadSchemaVersion(); version != 1 {
+		t.Errorf("got schema version %d, want the one of the source, 1", version)
+	}
+}
+
+func TestJSONStoreLegacyLayout(t *testing.T) {
+	dir := t.TempDir()
+	s := newJSONStore(dir)
+	os.MkdirAll(filepath.Join(dir, "data"), 0755)
+	os.WriteFile(s.path("inline.json"), []byte(`[{"gid":"-1001"}]`), 0644)
+	os.WriteFile(s.path("schema.json"), []byte(`{"version":2}`), 0644)
+
+	list, err := s.LoadGroups()
+	if err != nil || len(list) != 1 || list[0].Id != "-1001" {
+		t.Fatalf("got %v %v, want the group of the bare list", list, err)
+	}
+	if version, _ := s.LoadSchemaVersion(); version != 2 {
+		t.Errorf("got schema version %d, want the one of schema.json, 2", version)
+	}
+	if err := s.SaveGroups(list, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.path("schema.json")); !os.IsNotExist(err) {
+		t.Errorf("schema.json is kept after saving the groups with their version")
+	}
+	if version, _ := s.LoadSchemaVersion(); version != 3 {
+		t.Errorf("got schema version %d, want 3", version)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	tele "gopkg.in/telebot.v3"
@@ -11,52 +12,239 @@ import (
 func inlineMessageHandler(c tele.Context) error {
 	group := findGroupByContext(c)
 	user := group.GetUser(strconv.FormatInt(c.Sender().ID, 10))
-	botSetup := group.GetBotSetup(c.Message().Via.Username)
+	botSetup := group.ResolveBotSetup(c.Message().Via.Username)
+	// the per-user limit stays on the own entry of the bot, even when a pool counts it
+	botUserSetup := group.GetBotSetup(c.Message().Via.Username)
 	var resultLog string
+	kind := EventAllowed
+	now := time.Now()
+	weight := group.Setup.Weight(c.Message())
+	var burned string
 
-	if group.IsUserBurned(user) {
-		resultLog = "[BURNED](USER)"
+	if group.IsLockedDown(now) && !isAdminMessage(c) {
+		resultLog = "[LOCKDOWN]"
+		kind = EventDeleted
 		group.MsgCount("block")
-		c.Delete()
-		name := fmt.Sprintf("[%s](tg://user?id=%d)", escape(fullName(c.Sender())), c.Sender().ID)
-		warning := escape(fmt.Sprintf("your inline message burned out! It may take significant time for resetting. %d minutes left.", user.Cooldown))
-		sendSelfDestroyMsg(c.Recipient(), name+", "+warning, gWarningTimeout)
+		deleteAfterUnlock(c)
+	} else if group.BotPolicy(c.Message().Via.Username) == BotBan && group.IsDryRun() {
+		resultLog = "[BANNED](BOT)(DRYRUN)"
+		group.MsgCount("inline")
+		group.WouldBlockBot(c.Message().Via.Username)
+	} else if group.BotPolicy(c.Message().Via.Username) == BotBan {
+		resultLog = "[BANNED](BOT)"
+		kind = EventBlockedBot
+		group.MsgCount("block")
+		deleteAfterUnlock(c)
+	} else if group.IsUserExempt(user.Id) || (group.Setup.AdminsExempt && isAdminMessage(c)) {
+		resultLog = "[EXEMPT]"
+		group.MsgCount("inline")
+	} else if group.BotPolicy(c.Message().Via.Username) == BotAllow {
+		resultLog = "[ALLOWED](BOT)"
+		group.MsgCount("inline")
+	} else if tier, next := group.WeightedBurnout(user, now, weight); tier != "" {
+		burned = tier
+		if group.IsDryRun() {
+			resultLog = "[BURNED](USER)(DRYRUN)"
+			group.MsgCount("inline")
+			group.WouldBlockUser(user.Id, fullName(c.Sender()), group.LadderStep(user.Id, now).Action)
+		} else {
+			resultLog, kind = enforceUserBurnout(c, group, user, tier, next, weight, now)
+		}
+	} else if botUserSetup != nil && botUserSetup.IsUserBurned(user.Id, now) {
+		if group.IsDryRun() {
+			resultLog = "[BURNED](USER BOT)(DRYRUN)"
+			group.MsgCount("inline")
+			group.WouldBlockUser(user.Id, fullName(c.Sender()), ActionDelete)
+		} else {
+			resultLog = "[BURNED](USER BOT)"
+			kind = EventBlockedUser
+			group.MsgCount("block")
+			deleteAfterUnlock(c)
+			next := botUserSetup.UserNextAllowed(user.Id, now)
+			name := fmt.Sprintf("[%s](tg://user?id=%d)", escape(fullName(c.Sender())), c.Sender().ID)
+			warning := fmt.Sprintf("%s allows each user %d inline messages in %d minutes, and you used them up. %d minutes left, the next one is allowed at %s.", botUserSetup.Name(), botUserSetup.UserBurnoutLimit, botUserSetup.UserCooldownMinutes, minutesUntil(next, now), next.Format("15:04:05"))
+			sendAfterUnlock(c, name+", "+escape(warning), gWarningTimeout)
+		}
 	} else {
-		if group.IsBotBurned(c.Message().Via.Username) {
+		if group.IsBotBurned(c.Message().Via.Username) && group.IsDryRun() {
+			resultLog = "[BURNED](BOT)(DRYRUN)"
+			group.MsgCount("inline")
+			group.WouldBlockBot(botSetup.Id)
+		} else if group.IsBotBurned(c.Message().Via.Username) {
 			resultLog = "[BURNED](BOT)"
+			kind = EventBlockedBot
 			group.MsgCount("block")
-			c.Delete()
-			warning := "Bot @" + botSetup.Id + " burned out! It may take significant time for resetting."
+			deleteAfterUnlock(c)
+			warning := botSetup.Name() + " burned out! It may take significant time for resetting."
 			if !group.BotWarn(c.Message().Via.Username) {
-				warning += fmt.Sprintf(" Until %s.", time.Now().Add(time.Minute*time.Duration(botSetup.Cooldown)).Format("15:04"))
-				sendMsg(c.Recipient(), escape(warning))
+				warning += fmt.Sprintf(" Until %s.", botSetup.NextAllowed(&botSetup.Counter, now).Format("15:04"))
+				sendAfterUnlock(c, escape(warning), 0)
 			} else {
-				warning += fmt.Sprintf(" %d minutes left.", botSetup.Cooldown)
-				sendSelfDestroyMsg(c.Recipient(), escape(warning), gWarningTimeout)
+				warning += fmt.Sprintf(" %d minutes left.", minutesUntil(botSetup.NextAllowed(&botSetup.Counter, now), now))
+				sendAfterUnlock(c, escape(warning), gWarningTimeout)
 			}
 		} else {
 			resultLog = "[ALLOWED]"
-			group.UserCountAdd(user)
+			group.UserCountAdd(user, weight)
+			if group.IsUserBurned(user) {
+				if multiplier := group.RecordBurnout(user, now); multiplier > 1 {
+					resultLog = fmt.Sprintf("[ALLOWED](ESCALATED x%d)", multiplier)
+				}
+				if _, next := group.UserBurnout(user, now); group.Setup.Enforcement == EnforceRestrict && !group.IsDryRun() && group.RestrictUser(c.Chat(), c.Sender(), next) {
+					resultLog += "(RESTRICTED)"
+					name := fmt.Sprintf("[%s](tg://user?id=%d)", escape(fullName(c.Sender())), c.Sender().ID)
+					sendAfterUnlock(c, name+", "+restrictedNotice(next), gWarningTimeout)
+				}
+			}
 			if botSetup != nil {
 				botSetup.CountAdd()
 			}
+			if botUserSetup != nil {
+				botUserSetup.UserCountAdd(user.Id, now)
+			}
 			group.MsgCount("inline")
 		}
 	}
 
-	details := fmt.Sprintf("Chat %s\nUser @%s:%d/%d", group.Id, user.Id, user.Count, group.Setup.BurnoutLimit)
+	event := Event{Time: now, Kind: kind, Chat: group.Id, User: user.Id, Bot: c.Message().Via.Username, Result: resultLog, Tier: burned, Weight: weight}
+	setup, _ := group.UserSetup(user.Id, now)
+	details := fmt.Sprintf("Chat %s\nUser @%s:%d/%d", group.Id, user.Id, user.Count, setup.BurnoutLimit)
+	event.AddCount(gMainTier, user.Count, setup.BurnoutLimit)
+	for _, t := range setup.Tiers {
+		details += fmt.Sprintf(" %s:%d/%d", t.Name, user.TierCounter(t.Name).Count, t.BurnoutLimit)
+		event.AddCount(t.Name, user.TierCounter(t.Name).Count, t.BurnoutLimit)
+	}
 	if botSetup != nil {
-		details += fmt.Sprintf("\nBot @%s:%d/%d", botSetup.Id, botSetup.Count, botSetup.BurnoutLimit)
+		details += fmt.Sprintf("\n%s:%d/%d", botSetup.Name(), botSetup.Count, botSetup.BurnoutLimit)
+		event.AddCount(botSetup.Name(), botSetup.Count, botSetup.BurnoutLimit)
+	}
+	if botUserSetup != nil && botUserSetup.HasUserLimit() {
+		count := 0
+		if c, ok := botUserSetup.UserCounters[user.Id]; ok {
+			count = c.Count
+		}
+		if botUserSetup != botSetup {
+			details += "\n" + botUserSetup.Name()
+		}
+		details += fmt.Sprintf(" user:%d/%d", count, botUserSetup.UserBurnoutLimit)
+		event.AddCount(botUserSetup.Name()+" user", count, botUserSetup.UserBurnoutLimit)
 	}
 	msgLog.Info(resultLog, "detail", details)
+	logEvent(event)
 	return nil
 }
 
+// enforceUserBurnout takes the ladder step reached by an inline message, or a message of a limited content class, of a burned out user.
+// It returns the log tag and the event kind of the decision.
+// Content limits are never enforced by restriction, it would not stop most of the classes.
+func enforceUserBurnout(c tele.Context, group *GroupStat, user *User, tier string, next time.Time, weight int, now time.Time) (string, string) {
+	step := group.LadderStep(user.Id, now)
+	name := fmt.Sprintf("[%s](tg://user?id=%d)", escape(fullName(c.Sender())), c.Sender().ID)
+	resultLog := "[BURNED](USER)"
+	kind := EventBlockedUser
+	kept, blocked := "inline", "block"
+	if contentOf(tier) != "" {
+		resultLog = "[BURNED](CONTENT)"
+		kept, blocked = "chat", "contentblock"
+	}
+	switch {
+	case step.Action == ActionWarn:
+		resultLog += "(WARNED)"
+		kind = EventWarned
+		group.MsgCount(kept)
+		sendAfterUnlock(c, name+", "+userBurnoutWarning(group, user, tier, next, weight, now)+escape("\nThis one is kept, the next ones will not be."), gWarningTimeout)
+	case step.Action == ActionMute && group.MuteUser(c.Chat(), c.Sender(), step.Minutes):
+		resultLog += "(MUTED)"
+		group.MsgCount(blocked)
+		deleteAfterUnlock(c)
+		sendAfterUnlock(c, name+", "+escape(fmt.Sprintf("you keep sending inline messages over the limit, so you are muted for %d minutes.", step.Minutes)), gWarningTimeout)
+	case step.Action == ActionBan && group.BanUser(c.Chat(), c.Sender()):
+		resultLog += "(BANNED)"
+		group.MsgCount(blocked)
+		deleteAfterUnlock(c)
+		sendAfterUnlock(c, name+escape(" is banned for sending inline messages over the limit again and again."), 0)
+	default:
+		step = LadderStep{Action: ActionDelete}
+		group.MsgCount(blocked)
+		deleteAfterUnlock(c)
+		if group.Setup.Enforcement == EnforceRestrict && contentOf(tier) == "" && group.RestrictUser(c.Chat(), c.Sender(), next) {
+			resultLog += "(RESTRICTED)"
+			sendAfterUnlock(c, name+", "+restrictedNotice(next), gWarningTimeout)
+		} else {
+			sendAfterUnlock(c, name+", "+userBurnoutWarning(group, user, tier, next, weight, now), gWarningTimeout)
+		}
+	}
+	if len(group.Setup.Ladder) > 0 {
+		group.RecordAction(user.Id, step, now)
+	}
+	return resultLog, kind
+}
+
+// userBurnoutWarning explains to the user why the inline message is blocked and until when.
+// With weights, it also shows the remaining budget and what the message costs.
+func userBurnoutWarning(group *GroupStat, user *User, tier string, next time.Time, weight int, now time.Time) string {
+	var warning string
+	// the main limit of the user is custom, newcomer or main, the other tiers come from the group or the content limits
+	_, mainTier := group.UserSetup(user.Id, now)
+	if next.IsZero() && tier == mainTier && mainTier == gNewcomerTier {
+		warning = escape(fmt.Sprintf("new members can not send inline messages until %s.", group.ProbationEnd(user.Id, now).Format("01-02 15:04")))
+	} else if next.IsZero() {
+		warning = escape("inline messages are not allowed in this group.")
+	} else {
+		what := "inline message"
+		if class := contentOf(tier); class != "" {
+			what = class + " message"
+		}
+		warning = escape(fmt.Sprintf("your %s burned out! It may take significant time for resetting. %d minutes left, the next %s is allowed at %s.", what, minutesUntil(next, now), what, next.Format("15:04:05")))
+		switch {
+		case tier == mainTier && mainTier == gMainTier:
+			if group.IsRelaxed(now) {
+				warning += escape(fmt.Sprintf("\nThe relaxed limit of %s is active now.", group.Relax))
+			} else if i := group.Setup.ActiveRule(now); i >= 0 {
+				warning += escape(fmt.Sprintf("\nThe scheduled limit %s is active now.", group.Setup.Schedules[i]))
+			}
+		case tier == mainTier && mainTier == gNewcomerTier && group.Setup.Newcomer != nil:
+			warning += escape(fmt.Sprintf("\nNew members are allowed %d inline messages until %s.", group.Setup.Newcomer.BurnoutLimit, group.ProbationEnd(user.Id, now).Format("01-02 15:04")))
+		case tier == mainTier && mainTier == gCustomTier:
+			m := group.GetMember(user.Id)
+			warning += escape(fmt.Sprintf("\nYour custom limit is %d inline messages in %d minutes.", m.BurnoutLimit, m.CooldownMinutes))
+		case contentOf(tier) != "":
+			l := group.GetContentLimit(contentOf(tier))
+			warning += escape(fmt.Sprintf("\nThe limit of %s messages is %d in %d minutes.", l.Name, l.BurnoutLimit, l.CooldownMinutes))
+		default:
+			if t := group.GetTier(tier); t != nil {
+				warning += escape(fmt.Sprintf("\nThe limit of tier %s is %d inline messages in %d minutes.", t.Name, t.BurnoutLimit, t.CooldownMinutes))
+			}
+		}
+		if until, multiplier := group.EscalatedUntil(user.Id, now); !until.IsZero() {
+			warning += escape(fmt.Sprintf("\nYour cooldown is escalated x%d for burning out repeatedly.", multiplier))
+		}
+		if len(group.Setup.Weights) > 0 {
+			left, limit := group.UserRemaining(user, tier, now)
+			warning += escape(fmt.Sprintf("\nYou have %d of %d left, and this message costs %d.", left, limit, weight))
+		}
+	}
+	return warning
+}
+
+// restrictedNotice tells the user they can not send inline messages until the given time.
+func restrictedNotice(until time.Time) string {
+	return escape(fmt.Sprintf("you used up your inline messages, so you can not send them until %s.", until.Format("15:04:05")))
+}
+
 func chatMessageHandler(c tele.Context) error {
 	group := findGroupByContext(c)
 	group.MsgCount("chat")
+	if !strings.HasPrefix(c.Text(), "/") {
+		return nil
+	}
+	digest := group.setupDigest()
 	for _, fn := range cmdWithParamsHandlers {
 		if fn(c) {
+			if group.setupDigest() != digest {
+				logSetupEvent(c, group, c.Text())
+			}
+			requestFlush()
 			return nil
 		}
 	}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var gDBDir = "../db"
+
+// gSnapshotInterval debounces the snapshots of the counters
+var gSnapshotInterval = time.Minute
+
+var (
+	// groupsDirty is set when any group changed since the last save
+	groupsDirty atomic.Bool
+	// flushRequested is set by the handlers changing the setup, to save right after they release the group
+	flushRequested atomic.Bool
+	// saveMu keeps the saves from overlapping
+	saveMu sync.Mutex
+)
+
+func markDirty() {
+	groupsDirty.Store(true)
+}
+
+// requestFlush saves the groups as soon as the handler is done with its group.
+func requestFlush() {
+	flushRequested.Store(true)
+}
+
+// saveGroups writes every group to the database. It must not be called while holding the lock of a group.
+func saveGroups() error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	groupsDirty.Store(false)
+	err := store.SaveGroups(groups.Snapshot(), schemaVersion())
+	if err != nil {
+		groupsDirty.Store(true)
+		errLog.Error("Save groups", "err", err)
+	}
+	return err
+}
+
+// saveRequested saves the groups if a handler requested it.
+func saveRequested() {
+	if flushRequested.Swap(false) {
+		saveGroups()
+	}
+}
+
+// snapshotTimer saves the groups every gSnapshotInterval if anything changed.
+func snapshotTimer() {
+	interval := time.NewTicker(gSnapshotInterval)
+	defer interval.Stop()
+	for range interval.C {
+		if groupsDirty.Load() {
+			saveGroups()
+		}
+	}
+}
+
+// writeAtomic writes v as JSON into a temp file next to path, syncs it and renames it into place,
+// so a crash leaves either the old or the new file, never a partial one.
+func writeAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// Pools share one counter and one cooldown among a family of inline bots.
+// The pool is a BotSetup named after the pool, with the usernames and wildcard patterns of its bots.
+
+func (g *GroupStat) GetPool(name string) *BotSetup {
+	for i, v := range g.Pools {
+		if v.Id == name {
+			return &g.Pools[i]
+		}
+	}
+	return nil
+}
+func (g *GroupStat) NewPool(name string, cooldown int, burnout int) *BotSetup {
+	g.Pools = append(g.Pools, BotSetup{User: User{Id: name}, GroupSetup: GroupSetup{CooldownMinutes: cooldown, BurnoutLimit: burnout, Mode: g.Setup.Mode}, Pool: true})
+	return &g.Pools[len(g.Pools)-1]
+}
+func (g *GroupStat) RemovePool(name string) bool {
+	for i, v := range g.Pools {
+		if v.Id == name {
+			g.Pools = append(g.Pools[:i], g.Pools[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns how the limited bot or pool is called in messages.
+func (b *BotSetup) Name() string {
+	if b.Pool {
+		return "Pool " + b.Id
+	}
+	return "Bot @" + b.Id
+}
+
+// Matches reports whether the bot username matches a pattern of the pool, case insensitively.
+func (b *BotSetup) Matches(username string) bool {
+	username = strings.ToLower(username)
+	for _, p := range b.Patterns {
+		if ok, _ := path.Match(strings.ToLower(p), username); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPattern adds a bot username or a wildcard pattern like "*gifbot" to the pool.
+func (b *BotSetup) AddPattern(pattern string) error {
+	pattern = strings.TrimPrefix(pattern, "@")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	for _, p := range b.Patterns {
+		if strings.EqualFold(p, pattern) {
+			return nil
+		}
+	}
+	b.Patterns = append(b.Patterns, pattern)
+	return nil
+}
+
+// RemovePattern removes a bot username or a wildcard pattern from the pool.
+func (b *BotSetup) RemovePattern(pattern string) bool {
+	pattern = strings.TrimPrefix(pattern, "@")
+	for i, p := range b.Patterns {
+		if strings.EqualFold(p, pattern) {
+			b.Patterns = append(b.Patterns[:i], b.Patterns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveBotSetup returns the setup counting the inline messages via the bot,
+// the first pool matching it or its own limit, nil if it is not limited.
+func (g *GroupStat) ResolveBotSetup(username string) *BotSetup {
+	for i := range g.Pools {
+		if g.Pools[i].Matches(username) {
+			return &g.Pools[i]
+		}
+	}
+	return g.GetBotSetup(username)
+}
+
+// botRefresh refreshes the counter of a bot or pool and resets its warning when it cooled down.
+func botRefresh(bs *BotSetup, now time.Time) bool {
+	if bs.Refresh(&bs.Counter, now) {
+		bs.Warned = false
+		return true
+	}
+	return false
+}
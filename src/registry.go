@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// GroupRegistry holds the stat of every group by chat id.
+// Handlers and timers run concurrently, so each group has its own lock,
+// held by whoever uses the group, and the pointers stay valid as long as the bot runs.
+type GroupRegistry struct {
+	mu     sync.RWMutex
+	groups map[string]*GroupStat
+}
+
+var groups = &GroupRegistry{groups: make(map[string]*GroupStat)}
+
+// Get returns the group of the chat id, creating it if needed.
+// The caller must hold the lock of the group before using it, see Each and lockGroupMiddleWare.
+func (r *GroupRegistry) Get(gid string) *GroupStat {
+	r.mu.RLock()
+	g := r.groups[gid]
+	r.mu.RUnlock()
+	if g != nil {
+		return g
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if g = r.groups[gid]; g == nil {
+		g = newGroup(gid)
+		r.groups[gid] = g
+	}
+	return g
+}
+
+// List returns the groups sorted by chat id.
+func (r *GroupRegistry) List() []*GroupStat {
+	r.mu.RLock()
+	list := make([]*GroupStat, 0, len(r.groups))
+	for _, g := range r.groups {
+		list = append(list, g)
+	}
+	r.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// Each calls fn on every group in turn, holding the lock of the group.
+func (r *GroupRegistry) Each(fn func(g *GroupStat)) {
+	for _, g := range r.List() {
+		g.mu.Lock()
+		fn(g)
+		g.unlock()
+	}
+}
+
+// later queues the Telegram call until the group is released, the caller holds the lock of the group.
+// The state is changed right away, so the call does not need the group.
+func (g *GroupStat) later(call func()) {
+	g.pending = append(g.pending, call)
+}
+
+// unlock releases the group, then runs the calls queued by later.
+func (g *GroupStat) unlock() {
+	pending := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+	for _, call := range pending {
+		call()
+	}
+}
+
+// Snapshot returns a copy of every group, each one consistent, to be saved while the bot runs.
+func (r *GroupRegistry) Snapshot() []*GroupStat {
+	list := make([]*GroupStat, 0)
+	r.Each(func(g *GroupStat) {
+		list = append(list, g.clone())
+	})
+	return list
+}
+
+// Load replaces the groups with the ones read from the database.
+func (r *GroupRegistry) Load(list []*GroupStat) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.groups = make(map[string]*GroupStat, len(list))
+	for _, g := range list {
+		r.groups[g.Id] = g
+	}
+}
+
+// clone deep copies the group through its saved form.
+func (g *GroupStat) clone() *GroupStat {
+	c := &GroupStat{}
+	data, err := json.Marshal(g)
+	if err == nil {
+		err = json.Unmarshal(data, c)
+	}
+	if err != nil {
+		errLog.Error("Clone group", "chat", g.Id, "err", err)
+	}
+	return c
+}
+
+// gAfterUnlockKey keys the calls queued by afterUnlock in the context
+const gAfterUnlockKey = "afterUnlock"
+
+// lockGroupMiddleWare holds the lock of the group of the chat while the handler runs,
+// then runs the calls queued by later and afterUnlock and saves the groups if the handler changed the setup.
+// The admins of the chat are fetched before the lock, so the admin checks of the handler hit the cache.
+func lockGroupMiddleWare(fn tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.Message() != nil {
+			admins.refresh(c.Chat())
+		}
+		g := findGroupByContext(c)
+		var queue []func()
+		c.Set(gAfterUnlockKey, &queue)
+		g.mu.Lock()
+		err := fn(c)
+		g.unlock()
+		for _, call := range queue {
+			call()
+		}
+		markDirty()
+		saveRequested()
+		return err
+	}
+}
+
+// afterUnlock runs the Telegram call once the group of the context is released,
+// so the other messages of a busy group do not wait on it. Outside of lockGroupMiddleWare it runs right away.
+func afterUnlock(c tele.Context, call func()) {
+	if queue, ok := c.Get(gAfterUnlockKey).(*[]func()); ok {
+		*queue = append(*queue, call)
+		return
+	}
+	call()
+}
+
+// deleteAfterUnlock deletes the message of the context once its group is released.
+func deleteAfterUnlock(c tele.Context) {
+	afterUnlock(c, func() { c.Delete() })
+}
+
+// sendAfterUnlock sends the message to the chat of the context once its group is released, it is kept with a zero timeout.
+func sendAfterUnlock(c tele.Context, what string, timeout time.Duration) {
+	afterUnlock(c, func() { sendSelfDestroyMsg(c.Recipient(), what, timeout) })
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const testChatID = -1001
+
+// fakeTelegram answers every Bot API method the handlers call with a plausible result.
+func fakeTelegram(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getChatAdministrators"):
+		w.Write([]byte(`{"ok":true,"result":[]}`))
+	case strings.HasSuffix(r.URL.Path, "/getChat"):
+		w.Write([]byte(`{"ok":true,"result":{"id":-1001,"type":"supergroup"}}`))
+	case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+		w.Write([]byte(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":-1001,"type":"supergroup"}}}`))
+	default:
+		w.Write([]byte(`{"ok":true,"result":true}`))
+	}
+}
+
+func TestMain(m *testing.M) {
+	logInit()
+	server := httptest.NewServer(http.HandlerFunc(fakeTelegram))
+	var err error
+	if bot, err = tele.NewBot(tele.Settings{URL: server.URL, Token: "test", Offline: true}); err != nil {
+		panic(err)
+	}
+	dir, err := os.MkdirTemp("", "inlinelimit")
+	if err != nil {
+		panic(err)
+	}
+	store = newJSONStore(dir)
+	go eventWriter()
+	code := m.Run()
+	server.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func inlineContext(id int, user int64) tele.Context {
+	return bot.NewContext(tele.Update{Message: &tele.Message{
+		ID:       id,
+		Sender:   &tele.User{ID: user, FirstName: "user"},
+		Chat:     &tele.Chat{ID: testChatID, Type: tele.ChatSuperGroup},
+		Via:      &tele.User{Username: "gif", IsBot: true},
+		Unixtime: time.Now().Unix(),
+	}})
+}
+
+func countEvents(t *testing.T, kind string) int {
+	t.Helper()
+	flushEvents()
+	count := 0
+	err := store.EachEvent(func(e Event) error {
+		if e.Chat == "-1001" && (kind == "" || e.Kind == kind) {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// TestInlineMessageHandlerRace runs the handler of one chat from many goroutines, next to the timers
+// and the snapshots, for go test -race to catch any unguarded access to the group.
+func TestInlineMessageHandlerRace(t *testing.T) {
+	const senders, messages = 16, 50
+	groups.Load(nil)
+	g := groups.Get("-1001")
+	g.Setup.BurnoutLimit = 3
+	g.Setup.CooldownMinutes = 1
+	g.Setup.Tiers = []LimitTier{{Name: "hourly", BurnoutLimit: 10, CooldownMinutes: 60}}
+	g.Setup.Weights = map[string]int{"text": 2}
+	g.BotsSetup = []BotSetup{{GroupSetup: GroupSetup{CooldownMinutes: 1, BurnoutLimit: 100}, User: User{Id: "gif"}, UserCooldownMinutes: 1, UserBurnoutLimit: 5}}
+	botStat.LastSummarySentTime = time.Now().Add(-25 * time.Hour)
+	before := countEvents(t, "")
+
+	handler := lockGroupMiddleWare(msgHandler)
+	done := make(chan struct{})
+	var timers sync.WaitGroup
+	timers.Add(1)
+	go func() {
+		defer timers.Done()
+		summaryRoutine()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				inlineCooldownRoutine()
+				groups.Snapshot()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(user int64) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				if err := handler(inlineContext(i, user)); err != nil {
+					t.Error(err)
+				}
+			}
+		}(int64(s%4 + 1))
+	}
+	wg.Wait()
+	close(done)
+	timers.Wait()
+
+	if got := countEvents(t, "") - before; got != senders*messages {
+		t.Errorf("got %d events, want one per message, %d", got, senders*messages)
+	}
+	if countEvents(t, EventBlockedUser) == 0 {
+		t.Error("no message was blocked over the user limit")
+	}
+	if len(g.Users) != 4 {
+		t.Errorf("got %d users, want 4", len(g.Users))
+	}
+	for _, u := range g.Users {
+		if u.Count > g.Setup.BurnoutLimit {
+			t.Errorf("user %s counted %d over the limit %d", u.Id, u.Count, g.Setup.BurnoutLimit)
+		}
+	}
+}
+
+func TestEachRunsQueuedCallsAfterUnlock(t *testing.T) {
+	g := groups.Get("-1002")
+	ran := false
+	groups.Each(func(group *GroupStat) {
+		if group != g {
+			return
+		}
+		g.later(func() {
+			if !g.mu.TryLock() {
+				t.Error("the queued call runs while the group is locked")
+				return
+			}
+			g.mu.Unlock()
+			ran = true
+		})
+	})
+	if !ran {
+		t.Error("the queued call did not run")
+	}
+	if len(g.pending) != 0 {
+		t.Errorf("got %d queued calls left, want none", len(g.pending))
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Relaxation replaces the user limit of the group until a given time, for events like game nights.
+// The setup itself is left untouched, so it is back in effect as soon as the relaxation ends.
+type Relaxation struct {
+	CooldownMinutes int
+	BurnoutLimit    int
+	Until           time.Time
+}
+
+func (r *Relaxation) String() string {
+	return fmt.Sprintf("%d inline messages in %d minutes until %s", r.BurnoutLimit, r.CooldownMinutes, r.Until.Format("01-02 15:04"))
+}
+
+// IsRelaxed reports whether the relaxation is in effect at now.
+func (g *GroupStat) IsRelaxed(now time.Time) bool {
+	return g.Relax != nil && now.Before(g.Relax.Until)
+}
+
+// RelaxRoutine reverts to the setup of the group when the relaxation is over and announces it.
+func (g *GroupStat) RelaxRoutine(now time.Time) {
+	if g.Relax == nil || g.IsRelaxed(now) {
+		return
+	}
+	g.Relax = nil
+	gid, _ := strconv.ParseInt(g.Id, 10, 64)
+	msg := escape(fmt.Sprintf("The relaxed limit is over, users are allowed %d inline messages in %d minutes again.", g.Setup.BurnoutLimit, g.Setup.CooldownMinutes))
+	g.later(func() { sendMsg(tele.ChatID(gid), msg) })
+	timerLog.Info("[RELAX END]", "detail", "Chat "+g.Id)
+}
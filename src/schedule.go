@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScheduleRule replaces the user limit of GroupSetup while it is active.
+// A rule ending before it starts runs past midnight into the next day.
+type ScheduleRule struct {
+	Weekdays []time.Weekday
+	// Minutes from midnight
+	Start           int
+	End             int
+	CooldownMinutes int
+	BurnoutLimit    int
+}
+
+var gWeekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// gLocations caches the timezones by name, the schedule is evaluated on every inline message.
+var gLocations = map[string]*time.Location{}
+var gLocationsMu sync.Mutex
+
+// loadLocation returns the timezone of the tz database with the name, loading it once.
+func loadLocation(name string) (*time.Location, error) {
+	gLocationsMu.Lock()
+	defer gLocationsMu.Unlock()
+	if loc, ok := gLocations[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	gLocations[name] = loc
+	return loc, nil
+}
+
+// Location returns the timezone to evaluate the schedule in, the TZ of the bot by default.
+func (s GroupSetup) Location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+	loc, err := loadLocation(s.Timezone)
+	if err != nil {
+		errLog.Warn("Unknown timezone, using the TZ of the bot", "tz", s.Timezone, "err", err)
+		gLocationsMu.Lock()
+		gLocations[s.Timezone] = time.Local
+		gLocationsMu.Unlock()
+		return time.Local
+	}
+	return loc
+}
+
+func (r ScheduleRule) hasWeekday(d time.Weekday) bool {
+	for _, v := range r.Weekdays {
+		if v == d {
+			return true
+		}
+	}
+	return false
+}
+
+// startOn returns when the rule starts on the day of t.
+func (r ScheduleRule) startOn(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, r.Start/60, r.Start%60, 0, 0, t.Location())
+}
+
+func (r ScheduleRule) length() time.Duration {
+	minutes := r.End - r.Start
+	if minutes <= 0 {
+		minutes += 24 * 60
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// IsActive reports whether t, in the timezone of the group, falls into the rule.
+func (r ScheduleRule) IsActive(t time.Time) bool {
+	for _, day := range []time.Time{t, t.AddDate(0, 0, -1)} {
+		start := r.startOn(day)
+		if r.hasWeekday(day.Weekday()) && !t.Before(start) && t.Before(start.Add(r.length())) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns when the rule starts next after t.
+func (r ScheduleRule) NextStart(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		if start := r.startOn(day); r.hasWeekday(day.Weekday()) && start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+func (r ScheduleRule) String() string {
+	days := make([]string, 0, len(r.Weekdays))
+	for _, d := range r.Weekdays {
+		days = append(days, gWeekdayNames[d])
+	}
+	return fmt.Sprintf("%s %02d:%02d-%02d:%02d: %d inline messages in %d minutes", strings.Join(days, ","), r.Start/60, r.Start%60, r.End/60, r.End%60, r.BurnoutLimit, r.CooldownMinutes)
+}
+
+// ActiveRule returns the index of the first schedule rule active at now, or -1.
+func (s GroupSetup) ActiveRule(now time.Time) int {
+	now = now.In(s.Location())
+	for i, r := range s.Schedules {
+		if r.IsActive(now) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Active returns the setup in effect at now, with the user limit of the active schedule rule.
+func (s GroupSetup) Active(now time.Time) GroupSetup {
+	if i := s.ActiveRule(now); i >= 0 {
+		s.BurnoutLimit = s.Schedules[i].BurnoutLimit
+		s.CooldownMinutes = s.Schedules[i].CooldownMinutes
+	}
+	return s
+}
+
+// parseWeekdays parses days like "mon-fri", "sat,sun" or "daily".
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	index := func(name string) (int, error) {
+		for i, v := range gWeekdayNames {
+			if v == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown weekday %s", name)
+	}
+	if s == "daily" {
+		s = "sun-sat"
+	}
+	seen := make([]bool, 7)
+	days := make([]time.Weekday, 0, 7)
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		a, err := index(from)
+		if err != nil {
+			return nil, err
+		}
+		b := a
+		if isRange {
+			if b, err = index(to); err != nil {
+				return nil, err
+			}
+		}
+		for i := a; ; i = (i + 1) % 7 {
+			if !seen[i] {
+				seen[i] = true
+				days = append(days, time.Weekday(i))
+			}
+			if i == b {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses "HH:MM" into minutes from midnight, "24:00" is allowed as an end.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+	return hour*60 + minute, nil
+}
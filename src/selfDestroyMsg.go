@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -14,39 +15,45 @@ type MsgWithTimeout struct {
 
 var msgs2Delete []MsgWithTimeout
 
+// msgs2DeleteMu guards msgs2Delete, appended by the handlers while the timer deletes from it
+var msgs2DeleteMu sync.Mutex
+
 func msgInit() {
-	msgs2Delete = make([]MsgWithTimeout, 0)
-	db.Read("data", "msg2delete", &msgs2Delete)
+	var err error
+	if msgs2Delete, err = store.LoadPendingDeletes(); err != nil {
+		errLog.Error("Load pending deletions", "err", err)
+	}
 	go oneSecondTimer()
 }
 
 func deleteAfter(msg tele.Editable, timeout time.Duration) {
 	m, c := msg.MessageSig()
 	msgStore := tele.StoredMessage{MessageID: m, ChatID: c}
+	msgs2DeleteMu.Lock()
+	defer msgs2DeleteMu.Unlock()
 	msgs2Delete = append(msgs2Delete, MsgWithTimeout{Msg: msgStore, Time: time.Now().Add(timeout)})
-	db.Write("data", "msg2delete", &msgs2Delete)
+	store.SavePendingDeletes(msgs2Delete)
 }
 
 func msgDeleteTimer() {
-	isMsg2Delete := false
-	for _, msg := range msgs2Delete {
-		if msg.Time.Before(time.Now()) {
-			isMsg2Delete = true
-			break
+	msgs2DeleteMu.Lock()
+	expired := make([]MsgWithTimeout, 0)
+	msgs2DeleteNew := make([]MsgWithTimeout, 0, len(msgs2Delete))
+	for _, m := range msgs2Delete {
+		if m.Time.Before(time.Now()) {
+			expired = append(expired, m)
+		} else {
+			msgs2DeleteNew = append(msgs2DeleteNew, m)
 		}
 	}
-	if isMsg2Delete {
-		msgs2DeleteNew := make([]MsgWithTimeout, 0)
-		for _, m := range msgs2Delete {
-			if m.Time.Before(time.Now()) {
-				bot.Delete(m.Msg)
-				log.Debug("[DELETE MSG]", "chatId", m.Msg.ChatID, "msgId", m.Msg.MessageID)
-			} else {
-				msgs2DeleteNew = append(msgs2DeleteNew, m)
-			}
-		}
+	if len(expired) > 0 {
 		msgs2Delete = msgs2DeleteNew
-		db.Write("data", "msg2delete", &msgs2Delete)
+		store.SavePendingDeletes(msgs2Delete)
+	}
+	msgs2DeleteMu.Unlock()
+	for _, m := range expired {
+		bot.Delete(m.Msg)
+		log.Debug("[DELETE MSG]", "chatId", m.Msg.ChatID, "msgId", m.Msg.MessageID)
 	}
 }
 
@@ -4,21 +4,66 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	tele "gopkg.in/telebot.v3"
 )
 
 const (
-	cmdHelp     string = "/help"
-	cmdHeatsink string = "/heatsink"
-	cmdSetup    string = `^/setup(?: (\d+),\s?(\d+))?$`
-	cmdBotLimit string = `^/botlimit(?: (\d+),\s?(\d+))?$`
+	cmdHelp         string = "/help"
+	cmdHeatsink     string = "/heatsink"
+	cmdExempt       string = "/exempt"
+	cmdUnexempt     string = "/unexempt"
+	cmdSetup        string = `^/setup(?: (\d+),\s?(\d+)(?: (fixed|sliding|bucket))?)?$`
+	cmdBotLimit     string = `^/botlimit(?: (\d+),\s?(\d+)(?: for (\d+)(m|h))?)?$`
+	cmdTier         string = `^/tier(?: (add|remove|list))?(?: (\w{1,32}))?(?: (\d+),\s?(\d+))?$`
+	cmdSchedule     string = `^/schedule(?: (add|list|del|tz))?(?: (.+))?$`
+	cmdUserLimit    string = `^/userlimit(?: (\d+),\s?(\d+))?$`
+	cmdAdminsExempt string = `^/adminsexempt(?: (on|off))?$`
+	cmdNewcomer     string = `^/newcomer(?: (off|(\d+) (\d+|ban)))?$`
+	cmdEscalation   string = `^/escalation(?: (off|(\d+),\s?(\d+) (\d+)))?$`
+	cmdEnforce      string = `^/enforce(?: (delete|restrict))?$`
+	cmdLadder       string = `^/ladder(?: (add|del|list|clear|history))?(?: (.+))?$`
+	cmdDryRun       string = `^/dryrun(?: (on|off))?$`
+	cmdLockdown     string = `^/lockdown(?: (off|(\d+)(m|h)))?$`
+	cmdBotBan       string = `^/botban(?: (off))?$`
+	cmdBotAllow     string = `^/botallow(?: (off))?$`
+	cmdBotAllowlist string = `^/botallowlist(?: (on|off))?$`
+	cmdBotUserLimit string = `^/botuserlimit(?: (\d+),\s?(\d+))?$`
+	cmdContentLimit string = `^/contentlimit(?: (list|sticker|animation|dice|poll|forward|voice))?(?: (off|(\d+),\s?(\d+)))?$`
+	cmdWeight       string = `^/weight(?: (list|text|photo|animation|video|sticker|document|audio|voice))?(?: (\d+))?$`
+	cmdPool         string = `^/pool(?: (create|add|remove|del|list))?(?: (\w{1,32}))?(?: (.+))?$`
+	cmdRelax        string = `^/relax(?: (off|(\d+),\s?(\d+) for (\d+)(m|h)))?$`
+	// parameters of /pool create
+	cmdPoolLimit string = `^(\d+),\s?(\d+)$`
+	// parameters of /ladder add
+	cmdLadderStep string = `^(\d+) (msg|burnout) (warn|delete|mute|ban)(?: (\d+))?$`
+	// parameters of /schedule add
+	cmdScheduleRule string = `^(\S+) (\d{1,2}:\d{2})-(\d{1,2}:\d{2}) (\d+),\s?(\d+)$`
 )
 
 var cmdWithParamsHandlers = []func(c tele.Context) bool{
 	onSetup,
 	onBotLimit,
+	onTier,
+	onSchedule,
+	onUserLimit,
+	onAdminsExempt,
+	onNewcomer,
+	onEscalation,
+	onEnforce,
+	onLadder,
+	onDryRun,
+	onLockdown,
+	onRelax,
+	onBotBan,
+	onBotAllow,
+	onBotAllowlist,
+	onPool,
+	onBotUserLimit,
+	onContentLimit,
+	onWeight,
 }
 
 // type cmdType int
@@ -35,20 +80,211 @@ var cmdWithParamsHandlers = []func(c tele.Context) bool{
 // }
 
 func onSetupHelp(c tele.Context) error {
-	reply := "Usage: `/setup <X>,<Y>`"
-	reply += "\nExample: `/setup 4,240`"
+	reply := "Usage: `/setup <X>,<Y> [mode]`"
+	reply += "\nExample: `/setup 4,240` or `/setup 4,240 sliding`"
 	reply += fmt.Sprintf("\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBurnoutLimitMin, gBurnoutLimitMax, gCooldownMinutesMin, gCooldownMinutesMax)
+	reply += escape("\n\nThe mode is one of:\nfixed - the count resets Y minutes after the first message\nsliding - a message is allowed if fewer than X messages were sent in the last Y minutes\nbucket - one message is given back every Y/X minutes, up to X\n\nThe mode applies to the user limit and all bot limits")
 	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
 }
 
 func onBotLimitHelp(c tele.Context) error {
-	reply := "Usage: REPLY to the inline message `/botlimit <X>,<Y>`"
-	reply += escape("\nExample: relpy to message {User via @InlineBot} with") + " `/botlimit 4,240`"
+	reply := "Usage: REPLY to the inline message `/botlimit <X>,<Y> [for <N>m|<N>h]`"
+	reply += escape("\nExample: relpy to message {User via @InlineBot} with") + " `/botlimit 4,240` or `/botlimit 2,60 for 12h`"
+	reply += fmt.Sprintf("\n\nThe valid X value is from %d to %d, the valid Y value is from %d to %d, and the time is from %d to %d minutes", gBotBurnoutLimitMin, gBotBurnoutLimitMax, gBotCooldownMinutesMin, gBotCooldownMinutesMax, gBotLimitMinutesMin, gBotLimitMinutesMax)
+	reply += escape("\nSet the X and Y value to 0 would remove the limit of the bot. With a time, the limit is removed when it is up.")
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func onPoolHelp(c tele.Context) error {
+	reply := "Usage: `/pool create <name> <X>,<Y>`, REPLY to the inline message `/pool add <name>` or `/pool remove <name>`, `/pool add <name> <pattern>`, `/pool remove <name> <pattern>`, `/pool del <name>` or `/pool list`"
+	reply += "\nExample: `/pool create gifs 4,240` and `/pool add gifs *gifbot`"
+	reply += fmt.Sprintf("\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBotBurnoutLimitMin, gBotBurnoutLimitMax, gBotCooldownMinutesMin, gBotCooldownMinutesMax)
+	reply += escape("\nThe bots of a pool share one limit, which replaces their own /botlimit. Patterns match bot usernames, * for any characters and ? for one.")
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func poolsText(group *GroupStat) string {
+	text := ""
+	for _, p := range group.Pools {
+		bots := "no bots"
+		if len(p.Patterns) > 0 {
+			bots = "@" + strings.Join(p.Patterns, ", @")
+		}
+		text += "\nPool " + p.Id + " of " + bots + " allowed " + strconv.Itoa(p.BurnoutLimit) + " messages in " + strconv.Itoa(p.CooldownMinutes) + " minutes."
+	}
+	return text
+}
+
+func onBotUserLimitHelp(c tele.Context) error {
+	reply := "Usage: REPLY to the inline message `/botuserlimit <X>,<Y>`"
+	reply += escape("\nExample: relpy to message {User via @InlineBot} with") + " `/botuserlimit 2,60`"
 	reply += fmt.Sprintf("\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBotBurnoutLimitMin, gBotBurnoutLimitMax, gBotCooldownMinutesMin, gBotCooldownMinutesMax)
-	reply += escape("\nSet the X and Y value to 0 would remove the limit of the bot.")
+	reply += escape("\nEach user burns out only their own access to the bot, besides the /botlimit of the whole group and any /pool the bot is in. Set the X and Y value to 0 would remove the per-user limit.")
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func onTierHelp(c tele.Context) error {
+	reply := "Usage: `/tier add <name> <X>,<Y>`, `/tier remove <name>` or `/tier list`"
+	reply += "\nExample: `/tier add burst 2,5` and `/tier add daily 10,1440`"
+	reply += fmt.Sprintf("\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBurnoutLimitMin, gBurnoutLimitMax, gCooldownMinutesMin, gCooldownMinutesMax)
+	reply += escape(fmt.Sprintf("\nAn inline message is blocked if the /setup limit or any tier is burned out. Up to %d tiers are allowed.", gTiersMax))
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func tiersText(group *GroupStat) string {
+	text := ""
+	for _, t := range group.Setup.Tiers {
+		text += "\nTier " + t.Name + " allowed " + strconv.Itoa(t.BurnoutLimit) + " inline messages in " + strconv.Itoa(t.CooldownMinutes) + " minutes."
+	}
+	return text
+}
+
+func onContentLimitHelp(c tele.Context) error {
+	reply := "Usage: `/contentlimit <class> <X>,<Y>`, `/contentlimit <class> off` or `/contentlimit list`"
+	reply += "\nExample: `/contentlimit sticker 5,10`"
+	reply += fmt.Sprintf("\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBurnoutLimitMin, gBurnoutLimitMax, gCooldownMinutesMin, gCooldownMinutesMax)
+	reply += escape("\nThe class is one of " + strings.Join(gContentClasses, ", ") + ". Each user is allowed X messages of the class in Y minutes, enforced like inline messages.")
 	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
 }
 
+func contentsText(group *GroupStat) string {
+	text := ""
+	for _, l := range group.Setup.Contents {
+		text += "\nContent " + l.Name + " allowed " + strconv.Itoa(l.BurnoutLimit) + " messages in " + strconv.Itoa(l.CooldownMinutes) + " minutes."
+	}
+	return text
+}
+
+func onWeightHelp(c tele.Context) error {
+	reply := "Usage: `/weight <type> <N>` or `/weight list`"
+	reply += "\nExample: `/weight animation 3` and `/weight video 4`"
+	reply += escape(fmt.Sprintf("\n\nThe valid N value is from %d to %d, 1 by default. The type is one of %s.", gWeightMin, gWeightMax, strings.Join(gWeightTypes, ", ")))
+	reply += escape("\nAn inline message is blocked if its weight would go over the user limits. A weight over the whole limit costs the whole limit.")
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func onScheduleHelp(c tele.Context) error {
+	reply := "Usage: `/schedule add <days> <HH:MM>-<HH:MM> <X>,<Y>`, `/schedule del <n>`, `/schedule tz <timezone>` or `/schedule list`"
+	reply += "\nExample: `/schedule add mon-fri 09:00-18:00 2,240` and `/schedule add sat,sun 20:00-02:00 8,240`"
+	reply += fmt.Sprintf("\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBurnoutLimitMin, gBurnoutLimitMax, gCooldownMinutesMin, gCooldownMinutesMax)
+	reply += escape(fmt.Sprintf("\nDays are mon, tue, wed, thu, fri, sat, sun, ranges of them or daily. While a rule is active, its limit replaces the /setup one. The first active rule wins. Up to %d rules are allowed.", gSchedulesMax))
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func schedulesText(group *GroupStat, now time.Time) string {
+	if len(group.Setup.Schedules) == 0 {
+		return ""
+	}
+	loc := group.Setup.Location()
+	now = now.In(loc)
+	active := group.Setup.ActiveRule(now)
+	text := "\nSchedule in " + loc.String() + " time:"
+	for i, r := range group.Setup.Schedules {
+		text += fmt.Sprintf("\n%d. %s", i+1, r)
+		if i == active {
+			text += " (active now)"
+		} else if next := r.NextStart(now); !next.IsZero() {
+			text += " (next " + next.Format("Mon 15:04") + ")"
+		}
+	}
+	return text
+}
+
+func onUserLimitHelp(c tele.Context) error {
+	reply := "Usage: REPLY to a message of the member `/userlimit <X>,<Y>`"
+	reply += "\nExample: `/userlimit 8,240`"
+	reply += fmt.Sprintf("\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBurnoutLimitMin, gBurnoutLimitMax, gCooldownMinutesMin, gCooldownMinutesMax)
+	reply += escape("\nThe custom limit replaces the limits of the group for the member. Set the X and Y value to 0 would remove it.")
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func membersText(group *GroupStat) string {
+	text := ""
+	for _, m := range group.Members {
+		if m.Exempt {
+			text += "\nMember " + m.Name + " is exempt."
+		} else if m.HasLimit() {
+			text += "\nMember " + m.Name + " allowed " + strconv.Itoa(m.BurnoutLimit) + " inline messages in " + strconv.Itoa(m.CooldownMinutes) + " minutes."
+		}
+	}
+	return text
+}
+
+func onNewcomerHelp(c tele.Context) error {
+	reply := "Usage: `/newcomer <H> <X>`, `/newcomer <H> ban` or `/newcomer off`"
+	reply += "\nExample: `/newcomer 24 1`"
+	reply += fmt.Sprintf("\n\nThe valid H value is from %d to %d, and the valid X value is from %d to %d", gNewcomerHoursMin, gNewcomerHoursMax, gBurnoutLimitMin, gBurnoutLimitMax)
+	reply += escape("\nMembers who joined in the last H hours are allowed X inline messages in the cooldown of the group, or none with ban.")
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func newcomerText(group *GroupStat) string {
+	p := group.Setup.Newcomer
+	if p == nil {
+		return ""
+	}
+	if p.Ban {
+		return "\nMembers who joined in the last " + strconv.Itoa(p.Hours) + " hours are not allowed inline messages."
+	}
+	return "\nMembers who joined in the last " + strconv.Itoa(p.Hours) + " hours allowed " + strconv.Itoa(p.BurnoutLimit) + " inline messages."
+}
+
+func onEscalationHelp(c tele.Context) error {
+	reply := "Usage: `/escalation <F>,<M> <H>` or `/escalation off`"
+	reply += "\nExample: `/escalation 2,8 24`"
+	reply += fmt.Sprintf("\n\nThe valid F value is from %d to %d, the valid M value is from %d to %d, and the valid H value is from %d to %d", gEscalationFactorMin, gEscalationFactorMax, gEscalationFactorMin, gEscalationMultiplierMax, gEscalationHoursMin, gEscalationHoursMax)
+	reply += escape("\nEach burnout within H hours multiplies the cooldown of the member by F, up to M times. The history is forgotten after H hours without burnouts.")
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func escalationText(group *GroupStat) string {
+	p := group.Setup.Escalation
+	if p == nil {
+		return ""
+	}
+	return fmt.Sprintf("\nRepeated burnouts within %d hours multiply the cooldown by %d, up to %d times.", p.LookbackHours, p.Factor, p.MaxMultiplier)
+}
+
+func onLadderHelp(c tele.Context) error {
+	reply := "Usage: `/ladder add <N> msg|burnout <action> [minutes]`, `/ladder del <n>`, `/ladder clear`, `/ladder list` or REPLY to a message of the member `/ladder history`"
+	reply += "\nExample:\n`/ladder add 1 msg warn`\n`/ladder add 2 msg delete`\n`/ladder add 3 burnout mute 1440`\n`/ladder add 5 burnout ban`"
+	reply += escape(fmt.Sprintf("\n\nA step applies from the N-th message over the limit since the last burnout (msg), or from the N-th burnout in a day (burnout). The most severe step reached is taken:\nwarn - keep the message and warn\ndelete - delete the message and warn, or restrict in restrict enforcement\nmute - delete the message and mute the member for the given minutes\nban - delete the message and ban the member\n\nThe valid N value is from %d to %d, and the valid minutes value is from %d to %d. Up to %d steps are allowed.", gLadderAtMin, gLadderAtMax, gMuteMinutesMin, gMuteMinutesMax, gLadderStepsMax))
+	return replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+}
+
+func ladderText(group *GroupStat) string {
+	if len(group.Setup.Ladder) == 0 {
+		return ""
+	}
+	text := "\nLadder:"
+	for i, l := range group.Setup.Ladder {
+		text += fmt.Sprintf("\n%d. %s", i+1, l)
+	}
+	return text
+}
+
+// repliedMember returns the user the command replies to, nil if it replies to no user.
+func repliedMember(c tele.Context) *tele.User {
+	if c.Message().ReplyTo == nil || c.Message().ReplyTo.Sender == nil || c.Message().ReplyTo.Sender.IsBot {
+		return nil
+	}
+	return c.Message().ReplyTo.Sender
+}
+
+// parseUserLimit parses the X and Y of a user limit, ok is false if they are out of range.
+func parseUserLimit(x string, y string) (burnout int, cooldown int, ok bool) {
+	burnout, err1 := strconv.Atoi(x)
+	cooldown, err2 := strconv.Atoi(y)
+	ok = err1 == nil && err2 == nil &&
+		burnout >= gBurnoutLimitMin && burnout <= gBurnoutLimitMax &&
+		cooldown >= gCooldownMinutesMin && cooldown <= gCooldownMinutesMax
+	return
+}
+func replyInvalidUserLimit(c tele.Context) {
+	reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBurnoutLimitMin, gBurnoutLimitMax, gCooldownMinutesMin, gCooldownMinutesMax))
+	bot.Reply(c.Message(), reply, tele.ModeMarkdownV2)
+}
+
 func onHelp(c tele.Context) error {
 	group := findGroupByContext(c)
 
@@ -58,25 +294,107 @@ func onHelp(c tele.Context) error {
 	help += "\n/help - display help message"
 	help += "\n/heatsink - immediately cooldown for everything"
 	help = escape(help)
-	help += "\n`/setup <X>,<Y>`" + escape(" - setting user burnout to be triggered by sending X inline messages in Y minutes")
-	help += "\n`/botlimit <X>,<Y>`" + escape(" - reply to the inline message to set the limit of the sender bot")
+	help += "\n`/setup <X>,<Y> [fixed|sliding|bucket]`" + escape(" - setting user burnout to be triggered by sending X inline messages in Y minutes")
+	help += "\n`/botlimit <X>,<Y> [for <N>h]`" + escape(" - reply to the inline message to set the limit of the sender bot")
+	help += "\n`/botuserlimit <X>,<Y>`" + escape(" - reply to the inline message to limit each user of the sender bot")
+	help += "\n`/botban [off]`" + escape(" - reply to the inline message to delete every message via the sender bot")
+	help += "\n`/botallow [off]`" + escape(" - reply to the inline message to never count the messages via the sender bot")
+	help += "\n`/botallowlist on|off`" + escape(" - whether only the allowed bots can be used")
+	help += "\n`/pool create|add|remove|del|list`" + escape(" - manage pools of bots sharing one limit")
+	help += "\n`/tier add|remove|list`" + escape(" - manage extra limits checked together with the /setup one")
+	help += "\n`/weight <type> <N>`" + escape(" - make an inline message of the type cost N against the user limits")
+	help += "\n`/contentlimit <class> <X>,<Y>|off`" + escape(" - limit stickers, GIFs, dice, polls, forwards from channels or voice messages like inline messages")
+	help += "\n`/schedule add|del|tz|list`" + escape(" - manage limits replacing the /setup one at certain times")
+	help += "\n`/userlimit <X>,<Y>`" + escape(" - reply to a message to set a custom limit of the member")
+	help += escape("\n/exempt - reply to a message to make the member unlimited")
+	help += escape("\n/unexempt - reply to a message to remove the exemption of the member")
+	help += "\n`/adminsexempt on|off`" + escape(" - whether the admins of the group are limited")
+	help += "\n`/newcomer <H> <X>|ban|off`" + escape(" - limit the members who joined in the last H hours")
+	help += "\n`/escalation <F>,<M> <H>|off`" + escape(" - multiply the cooldown of repeated burnouts")
+	help += "\n`/enforce delete|restrict`" + escape(" - delete the messages over the limit, or restrict the burned out members until their cooldown ends")
+	help += "\n`/ladder add|del|clear|list|history`" + escape(" - manage the steps taken against the messages over the limit")
+	help += "\n`/dryrun on|off`" + escape(" - only observe and report what would have been blocked to you in private")
+	help += "\n`/lockdown <N>m|<N>h|off`" + escape(" - delete every inline message for a while")
+	help += "\n`/relax <X>,<Y> for <N>h|off`" + escape(" - replace the user limit for a while, then go back to the setup")
 
-	help += escape("\n\nCurrent setup:\nUser allowed " + strconv.Itoa(group.Setup.BurnoutLimit) + " inline messages in " + strconv.Itoa(group.Setup.CooldownMinutes) + " minutes.")
+	help += escape("\n\nCurrent setup:\nUser allowed " + strconv.Itoa(group.Setup.BurnoutLimit) + " inline messages in " + strconv.Itoa(group.Setup.CooldownMinutes) + " minutes (" + group.Setup.ModeName() + " mode)." + weightsText(group) + tiersText(group) + contentsText(group) + schedulesText(group, time.Now()) + membersText(group) + newcomerText(group) + escalationText(group))
+	if group.Setup.AdminsExempt {
+		help += escape("\nAdmins are exempt.")
+	}
+	help += escape("\nEnforcement: " + group.Setup.EnforcementName() + "." + ladderText(group))
+	if group.IsRelaxed(time.Now()) {
+		help += escape("\nRelaxed to " + group.Relax.String() + ".")
+	}
+	if group.IsLockedDown(time.Now()) {
+		help += escape("\nLocked down until " + group.LockdownUntil.Format("01-02 15:04") + ".")
+	}
+	if group.IsDryRun() {
+		help += escape(fmt.Sprintf("\nDry run since %s, %d inline messages would have been blocked.", group.DryRun.Since.Format("01-02 15:04"), group.WouldBlockCount))
+	}
+	help += escape(botPoliciesText(group))
 	if len(group.BotsSetup) > 0 {
 		for _, v := range group.BotsSetup {
-			help += escape("\nBot @" + v.Id + " allowed " + strconv.Itoa(v.BurnoutLimit) + " messages in " + strconv.Itoa(v.CooldownMinutes) + " minutes.")
+			help += escape("\nBot @" + v.Id)
+			if v.HasBotLimit() {
+				help += escape(" allowed " + strconv.Itoa(v.BurnoutLimit) + " messages in " + strconv.Itoa(v.CooldownMinutes) + " minutes")
+			}
+			if v.HasBotLimit() && v.HasUserLimit() {
+				help += escape(" and")
+			}
+			if v.HasUserLimit() {
+				help += escape(" allowed " + strconv.Itoa(v.UserBurnoutLimit) + " messages in " + strconv.Itoa(v.UserCooldownMinutes) + " minutes per user")
+			}
+			if !v.Until.IsZero() {
+				help += escape(" until " + v.Until.Format("01-02 15:04"))
+			}
+			help += escape(".")
 		}
 	}
+	help += escape(poolsText(group))
 	return sendSelfDestroyMsg(c.Recipient(), help, 300*time.Second)
 }
 
 func onHeatsink(c tele.Context) error {
-	findGroupByContext(c).Heatsink()
+	group := findGroupByContext(c)
+	group.Heatsink()
+	logEvent(Event{Kind: EventHeatsink, Chat: group.Id, User: strconv.FormatInt(c.Sender().ID, 10)})
+	requestFlush()
 	_, err := bot.Reply(c.Message(), escape("Everyone's burnout count has been reset."), tele.ModeMarkdownV2)
 	errLog.Error("Reply to message", "err", err)
 	return err
 }
 
+func onExempt(c tele.Context) error {
+	user := repliedMember(c)
+	if user == nil {
+		return replySelfDestroyMsg(c.Message(), escape("Usage: REPLY to a message of the member /exempt"), 60*time.Second)
+	}
+	group := findGroupByContext(c)
+	m := group.NewMember(strconv.FormatInt(user.ID, 10))
+	m.Name = fullName(user)
+	m.Exempt = true
+	logSetupEvent(c, group, "/exempt "+strconv.FormatInt(user.ID, 10))
+	requestFlush()
+	_, err := bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\n%s is exempt from the limits now.", m.Name)), tele.ModeMarkdownV2)
+	return err
+}
+
+func onUnexempt(c tele.Context) error {
+	user := repliedMember(c)
+	if user == nil {
+		return replySelfDestroyMsg(c.Message(), escape("Usage: REPLY to a message of the member /unexempt"), 60*time.Second)
+	}
+	group := findGroupByContext(c)
+	if m := group.GetMember(strconv.FormatInt(user.ID, 10)); m != nil {
+		m.Exempt = false
+		group.CleanMembers()
+	}
+	logSetupEvent(c, group, "/unexempt "+strconv.FormatInt(user.ID, 10))
+	requestFlush()
+	_, err := bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\n%s is limited again.", fullName(user))), tele.ModeMarkdownV2)
+	return err
+}
+
 func onSetup(c tele.Context) bool {
 	group := findGroupByContext(c)
 	matchs := regexp.MustCompile(cmdSetup).FindStringSubmatch(c.Text())
@@ -97,7 +415,10 @@ func onSetup(c tele.Context) bool {
 		}
 		group.Setup.BurnoutLimit = burnout
 		group.Setup.CooldownMinutes = cooldown
-		bot.Reply(c.Message(), fmt.Sprintf("Setup update successful\nNow, user burnout is set to be triggered by sending more than `%d` inline messages in `%d` minutes", burnout, cooldown), tele.ModeMarkdownV2)
+		if len(matchs[3]) > 0 {
+			group.SetMode(matchs[3])
+		}
+		bot.Reply(c.Message(), fmt.Sprintf("Setup update successful\nNow, user burnout is set to be triggered by sending more than `%d` inline messages in `%d` minutes in `%s` mode", burnout, cooldown, group.Setup.ModeName()), tele.ModeMarkdownV2)
 		return true
 	}
 	return false
@@ -114,28 +435,771 @@ func onBotLimit(c tele.Context) bool {
 			botName := c.Message().ReplyTo.Via.Username
 			burnout, err1 := strconv.Atoi(matchs[1])
 			cooldown, err2 := strconv.Atoi(matchs[2])
-			if err1 != nil || err2 != nil || ((burnout != 0 && cooldown != 0) &&
+			var minutes int
+			var err3 error
+			if len(matchs[3]) > 0 {
+				minutes, err3 = strconv.Atoi(matchs[3])
+				if matchs[4] == "h" {
+					minutes *= 60
+				}
+			}
+			if err1 != nil || err2 != nil || err3 != nil || ((burnout != 0 && cooldown != 0) &&
 				(burnout < gBotBurnoutLimitMin || burnout > gBotBurnoutLimitMax ||
-					cooldown < gBotCooldownMinutesMin || cooldown > gBotCooldownMinutesMax)) {
-				reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBotBurnoutLimitMin, gBotBurnoutLimitMax, gBotCooldownMinutesMin, gBotCooldownMinutesMax))
+					cooldown < gBotCooldownMinutesMin || cooldown > gBotCooldownMinutesMax ||
+					(len(matchs[3]) > 0 && (minutes < gBotLimitMinutesMin || minutes > gBotLimitMinutesMax)))) {
+				reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid X value is from %d to %d, the valid Y value is from %d to %d, and the time is from %d to %d minutes", gBotBurnoutLimitMin, gBotBurnoutLimitMax, gBotCooldownMinutesMin, gBotCooldownMinutesMax, gBotLimitMinutesMin, gBotLimitMinutesMax))
 				bot.Reply(c.Message(), reply, tele.ModeMarkdownV2)
 				return true
 			}
 			if burnout == 0 && cooldown == 0 {
-				group.RemoveBotSetup(botName)
+				if bs := group.GetBotSetup(botName); bs != nil && bs.HasUserLimit() {
+					bs.ClearBotLimit()
+				} else {
+					group.RemoveBotSetup(botName)
+				}
 				bot.Reply(c.Message(), "Remove bot limit successful", tele.ModeMarkdownV2)
 			} else {
 				bs := group.GetBotSetup(botName)
 				if bs == nil {
-					group.NewBotSetup(botName, cooldown, burnout)
+					bs = &group.BotsSetup[group.NewBotSetup(botName, cooldown, burnout)]
 				} else {
 					bs.CooldownMinutes = cooldown
 					bs.BurnoutLimit = burnout
 				}
-				bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\nBot @%s's limit is set to %d messages in %d minutes", botName, burnout, cooldown)), tele.ModeMarkdownV2)
+				reply := fmt.Sprintf("Setup successful\nBot @%s's limit is set to %d messages in %d minutes", botName, burnout, cooldown)
+				bs.Until = time.Time{}
+				if minutes > 0 {
+					bs.Until = time.Now().Add(time.Duration(minutes) * time.Minute)
+					reply += " until " + bs.Until.Format("01-02 15:04")
+				}
+				bot.Reply(c.Message(), escape(reply), tele.ModeMarkdownV2)
 			}
 			return true
 		}
 	}
 	return false
 }
+
+func onTier(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdTier).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	name := matchs[2]
+	switch matchs[1] {
+	case "list":
+		reply := "User allowed " + strconv.Itoa(group.Setup.BurnoutLimit) + " inline messages in " + strconv.Itoa(group.Setup.CooldownMinutes) + " minutes." + tiersText(group)
+		replySelfDestroyMsg(c.Message(), escape(reply), 60*time.Second)
+	case "remove":
+		if len(name) == 0 {
+			onTierHelp(c)
+		} else if group.RemoveTier(name) {
+			bot.Reply(c.Message(), escape("Remove tier "+name+" successful"), tele.ModeMarkdownV2)
+		} else {
+			bot.Reply(c.Message(), escape("There is no tier "+name), tele.ModeMarkdownV2)
+		}
+	case "add":
+		if len(name) == 0 || len(matchs[3]) == 0 || len(matchs[4]) == 0 {
+			onTierHelp(c)
+			return true
+		}
+		burnout, cooldown, ok := parseUserLimit(matchs[3], matchs[4])
+		if !ok {
+			replyInvalidUserLimit(c)
+			return true
+		}
+		if name == gMainTier {
+			bot.Reply(c.Message(), escape("The main tier is set by /setup"), tele.ModeMarkdownV2)
+			return true
+		}
+		if name == gCustomTier {
+			bot.Reply(c.Message(), escape("The tier name custom is reserved for the custom limits of /userlimit"), tele.ModeMarkdownV2)
+			return true
+		}
+		if name == gNewcomerTier {
+			bot.Reply(c.Message(), escape("The tier name newcomer is reserved for the limit of /newcomer"), tele.ModeMarkdownV2)
+			return true
+		}
+		if t := group.GetTier(name); t != nil {
+			t.BurnoutLimit = burnout
+			t.CooldownMinutes = cooldown
+		} else if len(group.Setup.Tiers) >= gTiersMax {
+			bot.Reply(c.Message(), escape(fmt.Sprintf("Up to %d tiers are allowed", gTiersMax)), tele.ModeMarkdownV2)
+			return true
+		} else {
+			group.Setup.Tiers = append(group.Setup.Tiers, LimitTier{Name: name, BurnoutLimit: burnout, CooldownMinutes: cooldown})
+		}
+		bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\nTier %s is set to %d inline messages in %d minutes", name, burnout, cooldown)), tele.ModeMarkdownV2)
+	default:
+		onTierHelp(c)
+	}
+	return true
+}
+
+func onSchedule(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdSchedule).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	switch matchs[1] {
+	case "list":
+		reply := "User allowed " + strconv.Itoa(group.Setup.BurnoutLimit) + " inline messages in " + strconv.Itoa(group.Setup.CooldownMinutes) + " minutes outside of the schedule."
+		if len(group.Setup.Schedules) == 0 {
+			reply += "\nThere is no schedule rule."
+		}
+		replySelfDestroyMsg(c.Message(), escape(reply+schedulesText(group, time.Now())), 60*time.Second)
+	case "del":
+		n, err := strconv.Atoi(matchs[2])
+		if err != nil || n < 1 || n > len(group.Setup.Schedules) {
+			onScheduleHelp(c)
+			return true
+		}
+		group.Setup.Schedules = append(group.Setup.Schedules[:n-1], group.Setup.Schedules[n:]...)
+		bot.Reply(c.Message(), escape(fmt.Sprintf("Remove schedule rule %d successful", n)), tele.ModeMarkdownV2)
+	case "tz":
+		loc, err := loadLocation(matchs[2])
+		if len(matchs[2]) == 0 || err != nil {
+			bot.Reply(c.Message(), escape("Unknown timezone. Use a name of the tz database like Asia/Hong_Kong."), tele.ModeMarkdownV2)
+			return true
+		}
+		group.Setup.Timezone = loc.String()
+		bot.Reply(c.Message(), escape("Setup successful\nThe schedule is evaluated in "+loc.String()+" time"), tele.ModeMarkdownV2)
+	case "add":
+		params := regexp.MustCompile(cmdScheduleRule).FindStringSubmatch(matchs[2])
+		if len(params) == 0 {
+			onScheduleHelp(c)
+			return true
+		}
+		days, err1 := parseWeekdays(strings.ToLower(params[1]))
+		start, err2 := parseClock(params[2])
+		end, err3 := parseClock(params[3])
+		if err1 != nil || err2 != nil || err3 != nil || start >= 24*60 {
+			onScheduleHelp(c)
+			return true
+		}
+		burnout, cooldown, ok := parseUserLimit(params[4], params[5])
+		if !ok {
+			replyInvalidUserLimit(c)
+			return true
+		}
+		if len(group.Setup.Schedules) >= gSchedulesMax {
+			bot.Reply(c.Message(), escape(fmt.Sprintf("Up to %d schedule rules are allowed", gSchedulesMax)), tele.ModeMarkdownV2)
+			return true
+		}
+		rule := ScheduleRule{Weekdays: days, Start: start, End: end, BurnoutLimit: burnout, CooldownMinutes: cooldown}
+		group.Setup.Schedules = append(group.Setup.Schedules, rule)
+		bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\nSchedule rule %d: %s", len(group.Setup.Schedules), rule)), tele.ModeMarkdownV2)
+	default:
+		onScheduleHelp(c)
+	}
+	return true
+}
+
+func onUserLimit(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdUserLimit).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	user := repliedMember(c)
+	if len(matchs[1]) == 0 || len(matchs[2]) == 0 || user == nil {
+		onUserLimitHelp(c)
+		return true
+	}
+	group := findGroupByContext(c)
+	id := strconv.FormatInt(user.ID, 10)
+	if matchs[1] == "0" && matchs[2] == "0" {
+		if m := group.GetMember(id); m != nil {
+			m.BurnoutLimit = 0
+			m.CooldownMinutes = 0
+			group.CleanMembers()
+		}
+		bot.Reply(c.Message(), escape("Remove custom limit of "+fullName(user)+" successful"), tele.ModeMarkdownV2)
+		return true
+	}
+	burnout, cooldown, ok := parseUserLimit(matchs[1], matchs[2])
+	if !ok {
+		replyInvalidUserLimit(c)
+		return true
+	}
+	m := group.NewMember(id)
+	m.Name = fullName(user)
+	m.BurnoutLimit = burnout
+	m.CooldownMinutes = cooldown
+	bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\n%s is allowed %d inline messages in %d minutes", m.Name, burnout, cooldown)), tele.ModeMarkdownV2)
+	return true
+}
+
+func onAdminsExempt(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdAdminsExempt).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	if len(matchs[1]) == 0 {
+		replySelfDestroyMsg(c.Message(), "Usage: `/adminsexempt on` or `/adminsexempt off`", 60*time.Second)
+		return true
+	}
+	group := findGroupByContext(c)
+	group.Setup.AdminsExempt = matchs[1] == "on"
+	if group.Setup.AdminsExempt {
+		bot.Reply(c.Message(), escape("Setup successful\nAdmins are exempt from the limits now."), tele.ModeMarkdownV2)
+	} else {
+		bot.Reply(c.Message(), escape("Setup successful\nAdmins are limited like everyone else now."), tele.ModeMarkdownV2)
+	}
+	return true
+}
+
+func onNewcomer(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdNewcomer).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	switch {
+	case matchs[1] == "off":
+		group.Setup.Newcomer = nil
+		bot.Reply(c.Message(), escape("Remove newcomer limit successful"), tele.ModeMarkdownV2)
+	case len(matchs[2]) > 0:
+		hours, err1 := strconv.Atoi(matchs[2])
+		policy := &NewcomerPolicy{Hours: hours, Ban: matchs[3] == "ban"}
+		var err2 error
+		if !policy.Ban {
+			policy.BurnoutLimit, err2 = strconv.Atoi(matchs[3])
+		}
+		if err1 != nil || err2 != nil || hours < gNewcomerHoursMin || hours > gNewcomerHoursMax ||
+			policy.BurnoutLimit < gBurnoutLimitMin || policy.BurnoutLimit > gBurnoutLimitMax {
+			reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid H value is from %d to %d, and the valid X value is from %d to %d", gNewcomerHoursMin, gNewcomerHoursMax, gBurnoutLimitMin, gBurnoutLimitMax))
+			bot.Reply(c.Message(), reply, tele.ModeMarkdownV2)
+			return true
+		}
+		group.Setup.Newcomer = policy
+		bot.Reply(c.Message(), escape("Setup successful"+newcomerText(group)), tele.ModeMarkdownV2)
+	default:
+		onNewcomerHelp(c)
+	}
+	return true
+}
+
+func onEscalation(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdEscalation).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	switch {
+	case matchs[1] == "off":
+		group.Setup.Escalation = nil
+		bot.Reply(c.Message(), escape("Remove escalation successful"), tele.ModeMarkdownV2)
+	case len(matchs[2]) > 0:
+		factor, err1 := strconv.Atoi(matchs[2])
+		max, err2 := strconv.Atoi(matchs[3])
+		hours, err3 := strconv.Atoi(matchs[4])
+		if err1 != nil || err2 != nil || err3 != nil ||
+			factor < gEscalationFactorMin || factor > gEscalationFactorMax ||
+			max < gEscalationFactorMin || max > gEscalationMultiplierMax ||
+			hours < gEscalationHoursMin || hours > gEscalationHoursMax {
+			reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid F value is from %d to %d, the valid M value is from %d to %d, and the valid H value is from %d to %d", gEscalationFactorMin, gEscalationFactorMax, gEscalationFactorMin, gEscalationMultiplierMax, gEscalationHoursMin, gEscalationHoursMax))
+			bot.Reply(c.Message(), reply, tele.ModeMarkdownV2)
+			return true
+		}
+		group.Setup.Escalation = &EscalationPolicy{Factor: factor, MaxMultiplier: max, LookbackHours: hours}
+		bot.Reply(c.Message(), escape("Setup successful"+escalationText(group)), tele.ModeMarkdownV2)
+	default:
+		onEscalationHelp(c)
+	}
+	return true
+}
+
+func onEnforce(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdEnforce).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	if len(matchs[1]) == 0 {
+		reply := "Usage: `/enforce delete` or `/enforce restrict`"
+		reply += escape("\n\ndelete - delete the inline messages over the limit and warn the sender\nrestrict - take the right to send inline messages from the burned out members until their cooldown ends, it falls back to delete without the right to ban users")
+		replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+		return true
+	}
+	group := findGroupByContext(c)
+	group.Setup.Enforcement = matchs[1]
+	reply := "Setup successful\nEnforcement is set to " + matchs[1] + "."
+	if matchs[1] == EnforceRestrict && !canRestrict(c.Chat()) {
+		reply += "\nThe bot has no right to ban users, messages will be deleted until the right is given."
+	}
+	bot.Reply(c.Message(), escape(reply), tele.ModeMarkdownV2)
+	return true
+}
+
+func onLadder(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdLadder).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	switch matchs[1] {
+	case "list":
+		reply := ladderText(group)
+		if reply == "" {
+			reply = "There is no ladder, every message over the limit is deleted."
+		}
+		replySelfDestroyMsg(c.Message(), escape(strings.TrimPrefix(reply, "\n")), 60*time.Second)
+	case "clear":
+		group.Setup.Ladder = nil
+		bot.Reply(c.Message(), escape("Remove ladder successful"), tele.ModeMarkdownV2)
+	case "del":
+		n, err := strconv.Atoi(matchs[2])
+		if err != nil || n < 1 || n > len(group.Setup.Ladder) {
+			onLadderHelp(c)
+			return true
+		}
+		group.Setup.Ladder = append(group.Setup.Ladder[:n-1], group.Setup.Ladder[n:]...)
+		bot.Reply(c.Message(), escape(fmt.Sprintf("Remove ladder step %d successful", n)), tele.ModeMarkdownV2)
+	case "history":
+		user := repliedMember(c)
+		if user == nil {
+			onLadderHelp(c)
+			return true
+		}
+		reply := "Ladder steps taken against " + fullName(user) + ":"
+		m := group.GetMember(strconv.FormatInt(user.ID, 10))
+		if m == nil || len(m.Actions) == 0 {
+			reply += "\nnone"
+		} else {
+			for _, a := range m.Actions {
+				reply += "\n" + a.Time.Format("01-02 15:04") + " " + a.Action
+				if a.Minutes > 0 {
+					reply += fmt.Sprintf(" for %d minutes", a.Minutes)
+				}
+			}
+		}
+		replySelfDestroyMsg(c.Message(), escape(reply), 60*time.Second)
+	case "add":
+		params := regexp.MustCompile(cmdLadderStep).FindStringSubmatch(matchs[2])
+		if len(params) == 0 {
+			onLadderHelp(c)
+			return true
+		}
+		at, _ := strconv.Atoi(params[1])
+		step := LadderStep{At: at, Per: params[2], Action: params[3]}
+		if step.Action == ActionMute {
+			step.Minutes, _ = strconv.Atoi(params[4])
+		}
+		if at < gLadderAtMin || at > gLadderAtMax ||
+			(step.Action == ActionMute && (step.Minutes < gMuteMinutesMin || step.Minutes > gMuteMinutesMax)) {
+			reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid N value is from %d to %d, and the valid minutes value is from %d to %d", gLadderAtMin, gLadderAtMax, gMuteMinutesMin, gMuteMinutesMax))
+			bot.Reply(c.Message(), reply, tele.ModeMarkdownV2)
+			return true
+		}
+		if len(group.Setup.Ladder) >= gLadderStepsMax {
+			bot.Reply(c.Message(), escape(fmt.Sprintf("Up to %d ladder steps are allowed", gLadderStepsMax)), tele.ModeMarkdownV2)
+			return true
+		}
+		group.Setup.Ladder = append(group.Setup.Ladder, step)
+		reply := fmt.Sprintf("Setup successful\nLadder step %d: %s", len(group.Setup.Ladder), step)
+		if (step.Action == ActionMute || step.Action == ActionBan) && !canRestrict(c.Chat()) {
+			reply += "\nThe bot has no right to ban users, messages will be deleted until the right is given."
+		}
+		bot.Reply(c.Message(), escape(reply), tele.ModeMarkdownV2)
+	default:
+		onLadderHelp(c)
+	}
+	return true
+}
+
+func onDryRun(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdDryRun).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	if len(matchs[1]) == 0 {
+		reply := "Usage: `/dryrun on` or `/dryrun off`"
+		reply += escape("\n\nIn dry run, nothing is deleted and nobody is warned. The admin who enabled it gets private reports of who would have been blocked, so start a private chat with the bot first.")
+		replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+		return true
+	}
+	group := findGroupByContext(c)
+	if matchs[1] == "on" {
+		if !group.IsDryRun() {
+			group.DryRun = newDryRun(c.Sender().ID)
+		}
+		bot.Reply(c.Message(), escape("Setup successful\nDry run is on, the reports will be sent to "+fullName(c.Sender())+" in private."), tele.ModeMarkdownV2)
+	} else {
+		group.SendDryRunReport()
+		group.DryRun = nil
+		bot.Reply(c.Message(), escape("Setup successful\nDry run is off, inline messages are limited again."), tele.ModeMarkdownV2)
+	}
+	return true
+}
+
+func onLockdown(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdLockdown).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	if matchs[1] == "off" {
+		if group.IsLockedDown(time.Now()) {
+			// the routine announces the end
+			group.LockdownUntil = time.Now()
+			group.LockdownRoutine(time.Now())
+		} else {
+			bot.Reply(c.Message(), escape("There is no lockdown."), tele.ModeMarkdownV2)
+		}
+		return true
+	}
+	minutes, err := strconv.Atoi(matchs[2])
+	if matchs[3] == "h" {
+		minutes *= 60
+	}
+	if len(matchs[1]) == 0 || err != nil || minutes < gLockdownMinutesMin || minutes > gLockdownMinutesMax {
+		reply := "Usage: `/lockdown <N>m`, `/lockdown <N>h` or `/lockdown off`"
+		reply += "\nExample: `/lockdown 30m`"
+		reply += escape(fmt.Sprintf("\n\nEvery inline message is deleted for the given time, from %d to %d minutes. Admins are not locked down.", gLockdownMinutesMin, gLockdownMinutesMax))
+		replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+		return true
+	}
+	group.LockdownUntil = time.Now().Add(time.Duration(minutes) * time.Minute)
+	sendMsg(c.Recipient(), escape("Lockdown! Every inline message will be deleted until "+group.LockdownUntil.Format("15:04")+"."))
+	return true
+}
+
+func onRelax(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdRelax).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	if matchs[1] == "off" {
+		if group.IsRelaxed(time.Now()) {
+			// the routine announces the revert
+			group.Relax.Until = time.Now()
+			group.RelaxRoutine(time.Now())
+		} else {
+			bot.Reply(c.Message(), escape("The limit is not relaxed."), tele.ModeMarkdownV2)
+		}
+		return true
+	}
+	if len(matchs[1]) == 0 {
+		reply := "Usage: `/relax <X>,<Y> for <N>m`, `/relax <X>,<Y> for <N>h` or `/relax off`"
+		reply += "\nExample: `/relax 20,60 for 3h`"
+		reply += fmt.Sprintf("\n\nThe valid X value is from %d to %d, the valid Y value is from %d to %d, and the time is from %d to %d minutes", gBurnoutLimitMin, gBurnoutLimitMax, gCooldownMinutesMin, gCooldownMinutesMax, gRelaxMinutesMin, gRelaxMinutesMax)
+		reply += escape("\nThe limit replaces the /setup and scheduled ones for the given time, then the setup is back in effect.")
+		replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+		return true
+	}
+	burnout, err1 := strconv.Atoi(matchs[2])
+	cooldown, err2 := strconv.Atoi(matchs[3])
+	minutes, err3 := strconv.Atoi(matchs[4])
+	if matchs[5] == "h" {
+		minutes *= 60
+	}
+	if err1 != nil || err2 != nil || err3 != nil ||
+		burnout < gBurnoutLimitMin || burnout > gBurnoutLimitMax ||
+		cooldown < gCooldownMinutesMin || cooldown > gCooldownMinutesMax ||
+		minutes < gRelaxMinutesMin || minutes > gRelaxMinutesMax {
+		reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid X value is from %d to %d, the valid Y value is from %d to %d, and the time is from %d to %d minutes", gBurnoutLimitMin, gBurnoutLimitMax, gCooldownMinutesMin, gCooldownMinutesMax, gRelaxMinutesMin, gRelaxMinutesMax))
+		bot.Reply(c.Message(), reply, tele.ModeMarkdownV2)
+		return true
+	}
+	group.Relax = &Relaxation{CooldownMinutes: cooldown, BurnoutLimit: burnout, Until: time.Now().Add(time.Duration(minutes) * time.Minute)}
+	sendMsg(c.Recipient(), escape("The limit is relaxed! Users are allowed "+group.Relax.String()+", then the setup is back in effect."))
+	return true
+}
+
+// setRepliedBotPolicy sets the policy of the bot of the replied inline message, false if there is none.
+func setRepliedBotPolicy(c tele.Context, policy string, off bool) bool {
+	if c.Message().ReplyTo == nil || c.Message().ReplyTo.Via == nil {
+		return false
+	}
+	group := findGroupByContext(c)
+	botName := c.Message().ReplyTo.Via.Username
+	if off {
+		if group.BotPolicies[botName] == policy {
+			group.SetBotPolicy(botName, "")
+		}
+		bot.Reply(c.Message(), escape("Setup successful\nBot @"+botName+" is limited as usual now."), tele.ModeMarkdownV2)
+		return true
+	}
+	group.SetBotPolicy(botName, policy)
+	if policy == BotBan {
+		bot.Reply(c.Message(), escape("Setup successful\nEvery inline message via bot @"+botName+" will be deleted."), tele.ModeMarkdownV2)
+	} else {
+		bot.Reply(c.Message(), escape("Setup successful\nInline messages via bot @"+botName+" are never counted now."), tele.ModeMarkdownV2)
+	}
+	return true
+}
+
+func onBotBan(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdBotBan).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	if !setRepliedBotPolicy(c, BotBan, matchs[1] == "off") {
+		reply := "Usage: REPLY to the inline message `/botban` or `/botban off`"
+		reply += escape("\nEvery inline message via a banned bot is deleted, whoever sends it.")
+		replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+	}
+	return true
+}
+
+func onBotAllow(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdBotAllow).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	if !setRepliedBotPolicy(c, BotAllow, matchs[1] == "off") {
+		reply := "Usage: REPLY to the inline message `/botallow` or `/botallow off`"
+		reply += escape("\nInline messages via an allowed bot never count against the user or the bot limit.")
+		replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+	}
+	return true
+}
+
+func onBotAllowlist(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdBotAllowlist).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	if len(matchs[1]) == 0 {
+		reply := "Usage: `/botallowlist on` or `/botallowlist off`"
+		reply += escape("\nWhile it is on, only the bots allowed by /botallow can be used, inline messages via any other bot are deleted.")
+		replySelfDestroyMsg(c.Message(), reply, 60*time.Second)
+		return true
+	}
+	group := findGroupByContext(c)
+	group.Setup.BotAllowlistOnly = matchs[1] == "on"
+	if group.Setup.BotAllowlistOnly {
+		bot.Reply(c.Message(), escape("Setup successful\nOnly the allowed bots can be used now."), tele.ModeMarkdownV2)
+	} else {
+		bot.Reply(c.Message(), escape("Setup successful\nEvery bot not banned can be used now."), tele.ModeMarkdownV2)
+	}
+	return true
+}
+
+func onPool(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdPool).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	name := matchs[2]
+	pattern := strings.TrimPrefix(matchs[3], "@")
+	if len(pattern) == 0 && c.Message().ReplyTo != nil && c.Message().ReplyTo.Via != nil {
+		pattern = c.Message().ReplyTo.Via.Username
+	}
+	switch {
+	case matchs[1] == "list":
+		reply := poolsText(group)
+		if len(reply) == 0 {
+			reply = "\nThere is no pool."
+		}
+		replySelfDestroyMsg(c.Message(), escape(reply[1:]), 60*time.Second)
+	case matchs[1] == "create" && len(name) > 0:
+		limit := regexp.MustCompile(cmdPoolLimit).FindStringSubmatch(matchs[3])
+		if len(limit) == 0 {
+			onPoolHelp(c)
+			return true
+		}
+		burnout, err1 := strconv.Atoi(limit[1])
+		cooldown, err2 := strconv.Atoi(limit[2])
+		if err1 != nil || err2 != nil ||
+			burnout < gBotBurnoutLimitMin || burnout > gBotBurnoutLimitMax ||
+			cooldown < gBotCooldownMinutesMin || cooldown > gBotCooldownMinutesMax {
+			reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBotBurnoutLimitMin, gBotBurnoutLimitMax, gBotCooldownMinutesMin, gBotCooldownMinutesMax))
+			bot.Reply(c.Message(), reply, tele.ModeMarkdownV2)
+			return true
+		}
+		if p := group.GetPool(name); p != nil {
+			p.BurnoutLimit = burnout
+			p.CooldownMinutes = cooldown
+		} else {
+			group.NewPool(name, cooldown, burnout)
+		}
+		bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\nPool %s's limit is set to %d messages in %d minutes", name, burnout, cooldown)), tele.ModeMarkdownV2)
+	case (matchs[1] == "add" || matchs[1] == "remove") && len(name) > 0 && len(pattern) > 0:
+		p := group.GetPool(name)
+		if p == nil {
+			bot.Reply(c.Message(), escape("There is no pool "+name+", create it with /pool create first."), tele.ModeMarkdownV2)
+			return true
+		}
+		if matchs[1] == "remove" {
+			if !p.RemovePattern(pattern) {
+				bot.Reply(c.Message(), escape("@"+pattern+" is not in pool "+name+"."), tele.ModeMarkdownV2)
+				return true
+			}
+			bot.Reply(c.Message(), escape("Setup successful\n@"+pattern+" is removed from pool "+name+"."), tele.ModeMarkdownV2)
+			return true
+		}
+		if err := p.AddPattern(pattern); err != nil {
+			bot.Reply(c.Message(), escape("Invalid pattern "+pattern+"."), tele.ModeMarkdownV2)
+			return true
+		}
+		bot.Reply(c.Message(), escape("Setup successful\n@"+pattern+" shares the limit of pool "+name+" now."), tele.ModeMarkdownV2)
+	case matchs[1] == "del" && len(name) > 0:
+		if group.RemovePool(name) {
+			bot.Reply(c.Message(), escape("Setup successful\nPool "+name+" is removed."), tele.ModeMarkdownV2)
+		} else {
+			bot.Reply(c.Message(), escape("There is no pool "+name+"."), tele.ModeMarkdownV2)
+		}
+	default:
+		onPoolHelp(c)
+	}
+	return true
+}
+
+func onBotUserLimit(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdBotUserLimit).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	if len(matchs[1]) == 0 || c.Message().ReplyTo == nil || c.Message().ReplyTo.Via == nil {
+		onBotUserLimitHelp(c)
+		return true
+	}
+	group := findGroupByContext(c)
+	botName := c.Message().ReplyTo.Via.Username
+	burnout, err1 := strconv.Atoi(matchs[1])
+	cooldown, err2 := strconv.Atoi(matchs[2])
+	if err1 != nil || err2 != nil || ((burnout != 0 || cooldown != 0) &&
+		(burnout < gBotBurnoutLimitMin || burnout > gBotBurnoutLimitMax ||
+			cooldown < gBotCooldownMinutesMin || cooldown > gBotCooldownMinutesMax)) {
+		reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid X value is from %d to %d, and the valid Y value is from %d to %d", gBotBurnoutLimitMin, gBotBurnoutLimitMax, gBotCooldownMinutesMin, gBotCooldownMinutesMax))
+		bot.Reply(c.Message(), reply, tele.ModeMarkdownV2)
+		return true
+	}
+	bs := group.GetBotSetup(botName)
+	if burnout == 0 && cooldown == 0 {
+		if bs != nil && !bs.HasBotLimit() {
+			group.RemoveBotSetup(botName)
+		} else if bs != nil {
+			bs.UserBurnoutLimit = 0
+			bs.UserCooldownMinutes = 0
+			bs.UserCounters = nil
+		}
+		bot.Reply(c.Message(), escape("Setup successful\nBot @"+botName+" is not limited per user now."), tele.ModeMarkdownV2)
+		return true
+	}
+	if bs == nil {
+		bs = &group.BotsSetup[group.NewBotSetup(botName, 0, 0)]
+	}
+	bs.UserBurnoutLimit = burnout
+	bs.UserCooldownMinutes = cooldown
+	bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\nEach user is allowed %d messages via bot @%s in %d minutes", burnout, botName, cooldown)), tele.ModeMarkdownV2)
+	return true
+}
+
+func onContentLimit(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdContentLimit).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	class := matchs[1]
+	switch {
+	case class == "list":
+		reply := contentsText(group)
+		if len(reply) == 0 {
+			reply = "\nNo content class is limited."
+		}
+		replySelfDestroyMsg(c.Message(), escape(reply[1:]), 60*time.Second)
+	case len(class) == 0 || len(matchs[2]) == 0:
+		onContentLimitHelp(c)
+	case matchs[2] == "off":
+		group.RemoveContentLimit(class)
+		bot.Reply(c.Message(), escape("Setup successful\nThe "+class+" messages are not limited now."), tele.ModeMarkdownV2)
+	default:
+		burnout, cooldown, ok := parseUserLimit(matchs[3], matchs[4])
+		if !ok {
+			replyInvalidUserLimit(c)
+			return true
+		}
+		group.SetContentLimit(class, cooldown, burnout)
+		bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\nEach user is allowed %d %s messages in %d minutes", burnout, class, cooldown)), tele.ModeMarkdownV2)
+	}
+	return true
+}
+
+func onWeight(c tele.Context) bool {
+	matchs := regexp.MustCompile(cmdWeight).FindStringSubmatch(c.Text())
+	if len(matchs) == 0 {
+		return false
+	}
+	if !privilegeCheck(c) {
+		return true
+	}
+	group := findGroupByContext(c)
+	if matchs[1] == "list" {
+		reply := weightsText(group)
+		if len(reply) == 0 {
+			reply = "\nEvery inline message costs 1."
+		}
+		replySelfDestroyMsg(c.Message(), escape(reply[1:]), 60*time.Second)
+		return true
+	}
+	if len(matchs[1]) == 0 || len(matchs[2]) == 0 {
+		onWeightHelp(c)
+		return true
+	}
+	weight, err := strconv.Atoi(matchs[2])
+	if err != nil || weight < gWeightMin || weight > gWeightMax {
+		reply := escape(fmt.Sprintf("Invalid value.\n\nThe valid N value is from %d to %d", gWeightMin, gWeightMax))
+		bot.Reply(c.Message(), reply, tele.ModeMarkdownV2)
+		return true
+	}
+	group.Setup.SetWeight(matchs[1], weight)
+	bot.Reply(c.Message(), escape(fmt.Sprintf("Setup successful\nAn inline %s message costs %d now.", matchs[1], weight)), tele.ModeMarkdownV2)
+	return true
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// gBackupTimeFormat stamps the names of the backups
+const gBackupTimeFormat = "20060102-150405"
+
+// Storage keeps the state of the bot between runs.
+// Loading something never saved returns its zero value without error.
+type Storage interface {
+	LoadGroups() ([]*GroupStat, error)
+	// SaveGroups saves the groups together with the schema version of their data, in one write.
+	SaveGroups(groups []*GroupStat, version int) error
+	LoadPendingDeletes() ([]MsgWithTimeout, error)
+	SavePendingDeletes(msgs []MsgWithTimeout) error
+	LoadBotStat() (BotStat, error)
+	SaveBotStat(stat BotStat) error
+	// LoadSchemaVersion returns the schema version of the saved data, 0 for data saved before versioning.
+	LoadSchemaVersion() (int, error)
+	// Backup copies the saved data of the schema version aside and returns where to.
+	Backup(version int) (string, error)
+	// AppendEvents adds the events to the history at once, which is never rewritten but drops the oldest events past its size.
+	AppendEvents(events []Event) error
+	// EachEvent calls fn on every event of the history from the oldest, until fn returns an error.
+	EachEvent(fn func(e Event) error) error
+	Close() error
+}
+
+// Storage backends, chosen by the DB_BACKEND environment variable
+const (
+	// BackendJSON keeps every document in its own JSON file, the layout of older versions.
+	BackendJSON = "json"
+	// BackendBolt keeps everything in one transactional bbolt file.
+	BackendBolt = "bolt"
+)
+
+var store Storage
+
+func openStorage(backend string) (Storage, error) {
+	switch backend {
+	case "", BackendJSON:
+		return newJSONStore(gDBDir), nil
+	case BackendBolt:
+		return newBoltStore(gDBDir)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// storageInit opens the backend of DB_BACKEND, and migrates the data of DB_MIGRATE_FROM into it
+// once, as long as it holds no group yet.
+func storageInit() error {
+	var err error
+	backend := os.Getenv("DB_BACKEND")
+	if store, err = openStorage(backend); err != nil {
+		return err
+	}
+	from := os.Getenv("DB_MIGRATE_FROM")
+	if from == "" || from == backend {
+		return nil
+	}
+	// the groups are copied last, so a migration interrupted before them runs again
+	if list, err := store.LoadGroups(); err != nil || len(list) > 0 {
+		return err
+	}
+	src, err := openStorage(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := migrateStorage(src, store); err != nil {
+		return fmt.Errorf("migrate storage from %s: %w", from, err)
+	}
+	return nil
+}
+
+// migrateStorage copies everything kept by src into dst.
+// The groups are copied last, storageInit migrates again until they are there,
+// and the events already copied by an interrupted run are skipped.
+func migrateStorage(src Storage, dst Storage) error {
+	copied := 0
+	err := dst.EachEvent(func(e Event) error {
+		copied++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	batch := make([]Event, 0, gEventBatchMax)
+	err = src.EachEvent(func(e Event) error {
+		if copied > 0 {
+			copied--
+			return nil
+		}
+		if batch = append(batch, e); len(batch) < gEventBatchMax {
+			return nil
+		}
+		err := dst.AppendEvents(batch)
+		batch = batch[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err := dst.AppendEvents(batch); err != nil {
+			return err
+		}
+	}
+	msgs, err := src.LoadPendingDeletes()
+	if err != nil {
+		return err
+	}
+	if err := dst.SavePendingDeletes(msgs); err != nil {
+		return err
+	}
+	stat, err := src.LoadBotStat()
+	if err != nil {
+		return err
+	}
+	if err := dst.SaveBotStat(stat); err != nil {
+		return err
+	}
+	version, err := src.LoadSchemaVersion()
+	if err != nil {
+		return err
+	}
+	list, err := src.LoadGroups()
+	if err != nil {
+		return err
+	}
+	return dst.SaveGroups(list, version)
+}
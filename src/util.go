@@ -3,14 +3,13 @@ package main
 import (
 	"strconv"
 	"strings"
+	"time"
 
 	tele "gopkg.in/telebot.v3"
 )
 
 func findGroupByContext(c tele.Context) *GroupStat {
-	gid := strconv.FormatInt(c.Chat().ID, 10)
-	gkey := findGroupByGid(gid)
-	return &groups[gkey]
+	return groups.Get(strconv.FormatInt(c.Chat().ID, 10))
 }
 
 func escape(s string) string {
@@ -45,3 +44,12 @@ func hasPrivilege(c tele.Context) bool {
 	}
 	return true
 }
+
+// privilegeCheck tells the sender off if they are not an admin, it returns true for admins.
+func privilegeCheck(c tele.Context) bool {
+	if hasPrivilege(c) {
+		return true
+	}
+	replySelfDestroyMsg(c.Message(), escape("Only admins can use this command!"), 15*time.Second)
+	return false
+}
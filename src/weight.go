@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Content types of inline messages weighted by GroupSetup.Weights
+var gWeightTypes = []string{"text", "photo", "animation", "video", "sticker", "document", "audio", "voice"}
+
+// messageType returns the content type of the message among gWeightTypes, empty for the others.
+func messageType(m *tele.Message) string {
+	switch {
+	case m.Photo != nil:
+		return "photo"
+	case m.Animation != nil:
+		return "animation"
+	case m.Video != nil:
+		return "video"
+	case m.Sticker != nil:
+		return "sticker"
+	case m.Document != nil:
+		return "document"
+	case m.Audio != nil:
+		return "audio"
+	case m.Voice != nil:
+		return "voice"
+	case m.Text != "":
+		return "text"
+	}
+	return ""
+}
+
+// Weight returns what the message costs against the user limits, 1 unless weighted otherwise.
+func (s GroupSetup) Weight(m *tele.Message) int {
+	if w, ok := s.Weights[messageType(m)]; ok {
+		return w
+	}
+	return 1
+}
+
+// SetWeight sets the weight of the content type, the default weight 1 removes it.
+func (s *GroupSetup) SetWeight(t string, weight int) {
+	if weight == 1 {
+		delete(s.Weights, t)
+		return
+	}
+	if s.Weights == nil {
+		s.Weights = make(map[string]int)
+	}
+	s.Weights[t] = weight
+}
+
+// UserRemaining returns how much of the limit of the tier the user has left, and the limit.
+func (g *GroupStat) UserRemaining(u *User, tier string, now time.Time) (int, int) {
+	s, name := g.UserSetup(u.Id, now)
+	c := &u.Counter
+	if class := contentOf(tier); class != "" {
+		s, c = g.TierSetup(*g.GetContentLimit(class)), u.TierCounter(tier)
+	} else if t := g.GetTier(tier); tier != name && t != nil {
+		s, c = g.TierSetup(*t), u.TierCounter(tier)
+	}
+	return s.Remaining(c, now), s.BurnoutLimit
+}
+
+func weightsText(group *GroupStat) string {
+	if len(group.Setup.Weights) == 0 {
+		return ""
+	}
+	types := make([]string, 0, len(group.Setup.Weights))
+	for t := range group.Setup.Weights {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	text := "\nWeights:"
+	for i, t := range types {
+		if i > 0 {
+			text += ","
+		}
+		text += " " + t + "=" + strconv.Itoa(group.Setup.Weights[t])
+	}
+	return text + ", others 1."
+}